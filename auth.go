@@ -0,0 +1,212 @@
+package pqstream
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/tmc/pqstream/pqs"
+)
+
+// Principal is a caller allowed to subscribe and the tables it may subscribe to.
+type Principal struct {
+	Name string `json:"name"`
+	// Token authenticates the principal when sent as "authorization: Bearer <token>" metadata.
+	Token string `json:"token,omitempty"`
+	// Certificate authenticates the principal by the common name or a DNS name of its TLS client certificate.
+	Certificate string `json:"certificate,omitempty"`
+	// Grants are the tables the principal may subscribe to.
+	Grants []Grant `json:"grants"`
+	// Redactions are fields removed from the events sent to the principal.
+	Redactions Redactions `json:"redactions,omitempty"`
+}
+
+// Grant allows subscribing to the tables matching Tables in the schemas matching Schemas.
+// Both are regular expressions, empty ones match everything.
+type Grant struct {
+	Schemas string `json:"schemas,omitempty"`
+	Tables  string `json:"tables,omitempty"`
+
+	schemaRe, tableRe *regexp.Regexp
+}
+
+// Principals describes the callers allowed to subscribe.
+type Principals []Principal
+
+// DecodePrincipals reads principals specified in json format, i.e.
+//
+//	[{"name": "billing", "token": "s3cret", "grants": [{"schemas": "^public$", "tables": "^(orders|invoices)$"}]}]
+func DecodePrincipals(r io.Reader) (Principals, error) {
+	var p Principals
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return nil, err
+	}
+	for i := range p {
+		if p[i].Token == "" && p[i].Certificate == "" {
+			return nil, errors.Errorf("principal %q has neither a token nor a certificate", p[i].Name)
+		}
+	}
+	if err := p.compile(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// compile compiles the regular expressions of the grants.
+func (p Principals) compile() error {
+	for i := range p {
+		for j := range p[i].Grants {
+			if err := p[i].Grants[j].compile(); err != nil {
+				return errors.Wrap(err, "principal "+p[i].Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (g *Grant) compile() error {
+	var err error
+	if g.schemaRe, err = regexp.Compile(g.Schemas); err != nil {
+		return errors.Wrap(err, "grant schemas")
+	}
+	if g.tableRe, err = regexp.Compile(g.Tables); err != nil {
+		return errors.Wrap(err, "grant tables")
+	}
+	return nil
+}
+
+// WithPrincipals requires callers to authenticate as one of p and limits them to the tables granted to them.
+// NewServer fails if a grant has an invalid regular expression.
+func WithPrincipals(p Principals) ServerOption {
+	return func(s *Server) {
+		s.principals = make(Principals, len(p))
+		for i := range p {
+			s.principals[i] = p[i]
+			s.principals[i].Grants = append([]Grant(nil), p[i].Grants...)
+		}
+	}
+}
+
+// allows reports whether the principal may subscribe to t.
+func (p *Principal) allows(t table) bool {
+	for _, g := range p.Grants {
+		if g.schemaRe == nil || g.tableRe == nil {
+			continue
+		}
+		if g.schemaRe.MatchString(t.schema) && g.tableRe.MatchString(t.name) {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// StreamInterceptor returns an interceptor that authenticates callers of the PQStream service if principals
+// are configured.
+func (s *Server) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if len(s.principals) == 0 || !strings.HasPrefix(info.FullMethod, "/pqs.PQStream/") {
+			return handler(srv, ss)
+		}
+		p, err := s.authenticate(ss.Context())
+		if err != nil {
+			s.logger.WithField("method", info.FullMethod).WithError(err).Warnln("unauthenticated call")
+			return status.Error(codes.Unauthenticated, err.Error())
+		}
+		return handler(srv, &authenticatedStream{ss, context.WithValue(ss.Context(), principalKey{}, p)})
+	}
+}
+
+// principalName returns the name of the principal the caller authenticated as, or "" if there is none.
+func principalName(ctx context.Context) string {
+	if p, ok := ctx.Value(principalKey{}).(*Principal); ok {
+		return p.Name
+	}
+	return ""
+}
+
+// authenticatedStream carries the authenticated principal in its context.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticate returns the principal identified by the bearer token or TLS client certificate of a call.
+func (s *Server) authenticate(ctx context.Context) (*Principal, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, v := range md["authorization"] {
+			if !strings.HasPrefix(v, "Bearer ") {
+				continue
+			}
+			token := []byte(strings.TrimPrefix(v, "Bearer "))
+			for i := range s.principals {
+				p := &s.principals[i]
+				if p.Token != "" && subtle.ConstantTimeCompare(token, []byte(p.Token)) == 1 {
+					return p, nil
+				}
+			}
+			return nil, errors.New("invalid token")
+		}
+	}
+	if pr, ok := peer.FromContext(ctx); ok {
+		if info, ok := pr.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			cert := info.State.PeerCertificates[0]
+			names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+			for i := range s.principals {
+				p := &s.principals[i]
+				for _, n := range names {
+					if p.Certificate != "" && p.Certificate == n {
+						return p, nil
+					}
+				}
+			}
+			return nil, errors.Errorf("no principal for certificate %q", cert.Subject.CommonName)
+		}
+	}
+	return nil, errors.New("no credentials")
+}
+
+// authorize returns a function reporting whether the caller may receive events of a table. It is a
+// PermissionDenied error if the caller may not subscribe to any of the requested tables.
+func (s *Server) authorize(ctx context.Context, requested func(table) bool) (func(table) bool, error) {
+	if len(s.principals) == 0 {
+		return func(table) bool { return true }, nil
+	}
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "no credentials")
+	}
+	tableNames, err := s.tableNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tableNames {
+		if requested(t) && p.allows(t) {
+			return p.allows, nil
+		}
+	}
+	return nil, status.Errorf(codes.PermissionDenied, "%s may not subscribe to any of the requested tables", p.Name)
+}
+
+// restrictFilter returns a filter matching the events that match and belong to an allowed table.
+func restrictFilter(match func(*pqs.Event) bool, allowed func(table) bool) func(*pqs.Event) bool {
+	return func(e *pqs.Event) bool {
+		return allowed(table{schema: e.Schema, name: e.Table}) && match(e)
+	}
+}
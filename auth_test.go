@@ -0,0 +1,121 @@
+package pqstream
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/tmc/pqstream/pqs"
+)
+
+const testPrincipals = `[
+	{"name": "billing", "token": "s3cret", "grants": [{"schemas": "^public$", "tables": "^(orders|invoices)$"}]},
+	{"name": "reporting", "certificate": "reporting.example.com", "grants": [{"tables": "^orders$"}, {"schemas": "^reports$"}]}
+]`
+
+func TestDecodePrincipals(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr bool
+	}{
+		{"basic", testPrincipals, false},
+		{"invalid json", `[{"name": }]`, true},
+		{"no credentials", `[{"name": "anyone", "grants": [{}]}]`, true},
+		{"invalid grant", `[{"name": "bad", "token": "t", "grants": [{"tables": "("}]}]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodePrincipals(strings.NewReader(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DecodePrincipals() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithPrincipals_invalidGrant(t *testing.T) {
+	p := Principals{{Name: "bad", Token: "t", Grants: []Grant{{Tables: "("}}}}
+	if _, err := NewServer("", WithPrincipals(p)); err == nil || !strings.Contains(err.Error(), "grant tables") {
+		t.Errorf("NewServer() with an invalid grant error = %v, want grant tables error", err)
+	}
+}
+
+func TestServer_authenticate(t *testing.T) {
+	p, err := DecodePrincipals(strings.NewReader(testPrincipals))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{}
+	WithPrincipals(p)(s)
+
+	withToken := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	}
+	withCert := func(cn string, dnsNames ...string) context.Context {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}, DNSNames: dnsNames}
+		return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}},
+		}})
+	}
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		want    string
+		wantErr bool
+	}{
+		{"token", withToken("s3cret"), "billing", false},
+		{"wrong token", withToken("guess"), "", true},
+		{"certificate common name", withCert("reporting.example.com"), "reporting", false},
+		{"certificate dns name", withCert("client", "reporting.example.com"), "reporting", false},
+		{"unknown certificate", withCert("billing"), "", true},
+		{"no credentials", context.Background(), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.authenticate(tt.ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Name != tt.want {
+				t.Errorf("authenticate() = %v, want %v", got.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrincipal_allows(t *testing.T) {
+	p, err := DecodePrincipals(strings.NewReader(testPrincipals))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporting := &p[1]
+	tests := []struct {
+		t    table
+		want bool
+	}{
+		{table{"public", "orders"}, true},
+		{table{"sales", "orders"}, true},
+		{table{"reports", "daily"}, true},
+		{table{"public", "users"}, false},
+	}
+	for _, tt := range tests {
+		if got := reporting.allows(tt.t); got != tt.want {
+			t.Errorf("allows(%v) = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+	match := restrictFilter(func(*pqs.Event) bool { return true }, reporting.allows)
+	if match(&pqs.Event{Schema: "public", Table: "users"}) {
+		t.Error("restricted filter matched an event of a table that is not granted")
+	}
+	if !match(&pqs.Event{Schema: "public", Table: "orders"}) {
+		t.Error("restricted filter did not match an event of a granted table")
+	}
+}
@@ -8,26 +8,57 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
 
 	_ "net/http/pprof"
 
 	"github.com/google/gops/agent"
 	_ "golang.org/x/net/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
 	_ "github.com/kardianos/minwinsvc" // import minwinsvc for windows service support
 	"github.com/pkg/errors"
 	"github.com/tmc/pqstream/ctxutil"
 	"github.com/tmc/pqstream/pqs"
+	"github.com/tmc/pqstream/tlsutil"
 )
 
 var (
-	pqsdAddr    = flag.String("connect", ":7000", "pqsd address")
-	tableRegexp = flag.String("tables", ".*", "regexp of tables to match")
-	debugAddr   = flag.String("debugaddr", ":7001", "listen debug addr")
+	pqsdAddr     = flag.String("connect", ":7000", "pqsd address")
+	tableRegexp  = flag.String("tables", ".*", "regexp of tables to match")
+	schemaRegexp = flag.String("schemas", ".*", "regexp of schemas to match")
+	ops          = flag.String("ops", "", "comma separated operations to match (i.e. 'insert,delete'), all if empty")
+	columns      = flag.String("columns", "", "columns to include per table i.e. 'users:email,name;orders:total', all if empty")
+	filter       = flag.String("filter", "", "expression events must match i.e. 'payload.status == \"paid\" && payload.amount > 1000'")
+	debugAddr    = flag.String("debugaddr", ":7001", "listen debug addr")
+	resumeFrom   = flag.Uint64("resume-from", 0, "if non-zero, start streaming after this event position")
+	resumeEpoch  = flag.String("resume-epoch", "", "epoch of the -resume-from position")
+	reconnect    = flag.Bool("reconnect", true, "if true, reconnect and resume the stream when pqsd becomes unavailable")
+	snapshot     = flag.Bool("snapshot", false, "if true, start with the current contents of the matching tables")
+	format       = flag.String("format", "", "template to print events with i.e. '{{.Op}} {{.Table}} {{.Id}} {{time .ChangeTime}} lag={{lag .}}', JSON if empty")
+	profiles     = flag.String("redaction-profiles", "", "comma separated redaction profiles configured on pqsd to apply to events")
+	changeFormat = flag.String("change-format", "", "how the changes of updates are represented: 'merge-patch' (default), 'json-patch' or 'column-values'")
+	transactions = flag.Bool("transactions", false, "if true, show BEGIN and COMMIT events around the changes of each transaction")
+	useTLS       = flag.Bool("tls", false, "if true, connect with TLS verifying pqsd with the system CAs, implied by the other -tls flags")
+	tlsCA        = flag.String("tls-ca", "", "PEM bundle of CAs to verify pqsd with")
+	tlsCert      = flag.String("tls-cert", "", "PEM client certificate to present to pqsd")
+	tlsKey       = flag.String("tls-key", "", "PEM key of -tls-cert")
+	token        = flag.String("token", "", "bearer token to authenticate to pqsd with, requires TLS")
 )
 
+const reconnectInterval = time.Second
+
 func main() {
 	flag.Parse()
 	if err := run(ctxutil.BackgroundWithSignals()); err != nil {
@@ -44,34 +75,232 @@ func run(ctx context.Context) error {
 		return err
 	}
 
-	conn, err := grpc.DialContext(ctx, *pqsdAddr, grpc.WithInsecure())
+	creds, err := transportCredentials()
+	if err != nil {
+		return err
+	}
+	if *token != "" && !usesTLS() {
+		return errors.New("-token requires TLS so it is not sent in the clear, see -tls")
+	}
+	if *token != "" {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.Pairs("authorization", "Bearer "+*token))
+	}
+	conn, err := grpc.DialContext(ctx, *pqsdAddr, creds)
 	if err != nil {
 		return errors.Wrap(err, "dial")
 	}
 	defer conn.Close()
 
-	c, err := pqs.NewPQStreamClient(conn).Listen(ctx, &pqs.ListenRequest{
-		TableRegexp: *tableRegexp,
-	})
-	if err != nil {
+	req := &pqs.ListenRequest{
+		TableRegexp:  *tableRegexp,
+		SchemaRegexp: *schemaRegexp,
+		Filter:       *filter,
+		Transactions: *transactions,
+	}
+	for _, p := range strings.Split(*profiles, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			req.RedactionProfiles = append(req.RedactionProfiles, p)
+		}
+	}
+	if req.Ops, err = parseOps(*ops); err != nil {
+		return err
+	}
+	if req.Columns, err = parseColumns(*columns); err != nil {
+		return err
+	}
+	if req.ChangeFormat, err = parseChangeFormat(*changeFormat); err != nil {
 		return err
 	}
+
+	c := pqs.NewPQStreamClient(conn)
 	go func() {
 		<-ctx.Done()
 		log.Println("context done.")
 	}()
 	go http.ListenAndServe(*debugAddr, nil)
 
-	// TODO(tmc): add format flag to control output (probably offer text/template)
-	m := &jsonpb.Marshaler{}
+	position := streamPosition{epoch: *resumeEpoch, position: *resumeFrom}
+	resuming := false
+	for {
+		err := stream(ctx, c, req, &position)
+		if resuming && *snapshot && status.Code(err) == codes.OutOfRange {
+			// pqsd restarted or no longer has the events missed, start over.
+			log.Println("cannot resume, restarting with a snapshot, error:", err)
+			position = streamPosition{}
+			continue
+		}
+		if !*reconnect || status.Code(err) != codes.Unavailable {
+			return err
+		}
+		resuming = true
+		log.Println("stream interrupted, resuming after position", position.position, "error:", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(reconnectInterval):
+		}
+	}
+}
+
+// transportCredentials returns the dial option securing the connection to pqsd as requested by the tls flags.
+func transportCredentials() (grpc.DialOption, error) {
+	if !usesTLS() {
+		return grpc.WithInsecure(), nil
+	}
+	cfg, err := tlsutil.ClientConfig(tlsutil.ServerName(*pqsdAddr), *tlsCA, *tlsCert, *tlsKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "tls")
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(cfg)), nil
+}
+
+// usesTLS reports whether any of the tls flags is set.
+func usesTLS() bool {
+	return *useTLS || *tlsCA != "" || *tlsCert != "" || *tlsKey != ""
+}
+
+// streamPosition is the position of the last event printed and its epoch.
+type streamPosition struct {
+	epoch    string
+	position uint64
+}
+
+// stream prints events from a single Listen call and records the position of the last event printed.
+func stream(ctx context.Context, c pqs.PQStreamClient, req *pqs.ListenRequest, position *streamPosition) error {
+	req.ResumeFrom, req.ResumeEpoch = position.position, position.epoch
+	var (
+		s   interface{ Recv() (*pqs.Event, error) }
+		err error
+	)
+	if *snapshot && position.position == 0 {
+		s, err = c.ListenWithSnapshot(ctx, req)
+	} else {
+		s, err = c.Listen(ctx, req)
+	}
+	if err != nil {
+		return err
+	}
+	printEvent, err := newPrinter(*format)
+	if err != nil {
+		return err
+	}
 	for {
-		ev, err := c.Recv()
+		ev, err := s.Recv()
 		if err != nil {
 			return err
 		}
-		if err := m.Marshal(os.Stdout, ev); err != nil {
+		if err := printEvent(ev); err != nil {
 			return err
 		}
-		fmt.Println()
+		if ev.Position != 0 {
+			*position = streamPosition{epoch: ev.Epoch, position: ev.Position}
+		}
+	}
+}
+
+// newPrinter returns a function that prints events as JSON or, if format is set, with the text/template format.
+func newPrinter(format string) (func(*pqs.Event) error, error) {
+	m := &jsonpb.Marshaler{}
+	if format == "" {
+		return func(ev *pqs.Event) error {
+			if err := m.Marshal(os.Stdout, ev); err != nil {
+				return err
+			}
+			_, err := fmt.Println()
+			return err
+		}, nil
+	}
+	tmpl, err := template.New("format").Funcs(template.FuncMap{
+		// json renders a message, i.e. the payload, as JSON.
+		"json": func(pb proto.Message) (string, error) {
+			if v := reflect.ValueOf(pb); !v.IsValid() || v.IsNil() {
+				return "null", nil
+			}
+			return m.MarshalToString(pb)
+		},
+		// time renders a timestamp in RFC 3339 format.
+		"time": func(ts *timestamp.Timestamp) string {
+			if ts == nil {
+				return ""
+			}
+			return ptypes.TimestampString(ts)
+		},
+		// lag is the time between the change, or its commit, and pqsd receiving it.
+		"lag": func(ev *pqs.Event) time.Duration {
+			changed := ev.ChangeTime
+			if changed == nil {
+				changed = ev.CommitTime
+			}
+			from, err := ptypes.Timestamp(changed)
+			if err != nil {
+				return 0
+			}
+			to, err := ptypes.Timestamp(ev.ReceiveTime)
+			if err != nil {
+				return 0
+			}
+			return to.Sub(from)
+		},
+	}).Parse(format)
+	if err != nil {
+		return nil, errors.Wrap(err, "format")
+	}
+	return func(ev *pqs.Event) error {
+		if err := tmpl.Execute(os.Stdout, ev); err != nil {
+			return err
+		}
+		_, err := fmt.Println()
+		return err
+	}, nil
+}
+
+// parseOps parses a comma separated list of operation names.
+func parseOps(s string) ([]pqs.Operation, error) {
+	var ops []pqs.Operation
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		op, ok := pqs.Operation_value[name]
+		if !ok {
+			return nil, errors.Errorf("unknown operation %q", name)
+		}
+		ops = append(ops, pqs.Operation(op))
+	}
+	return ops, nil
+}
+
+// parseChangeFormat parses a change format name such as "json-patch".
+func parseChangeFormat(s string) (pqs.ChangeFormat, error) {
+	if s == "" {
+		return pqs.ChangeFormat_MERGE_PATCH, nil
+	}
+	f, ok := pqs.ChangeFormat_value[strings.ToUpper(strings.Replace(s, "-", "_", -1))]
+	if !ok {
+		return 0, errors.Errorf("unknown change format %q", s)
+	}
+	return pqs.ChangeFormat(f), nil
+}
+
+// parseColumns parses per table column lists in the form "table:col1,col2;table2:col3".
+func parseColumns(s string) (map[string]*pqs.ColumnSet, error) {
+	columns := make(map[string]*pqs.ColumnSet)
+	for _, spec := range strings.Split(s, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid column list %q, expected table:col1,col2", spec)
+		}
+		set := &pqs.ColumnSet{}
+		for _, c := range strings.Split(parts[1], ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				set.Names = append(set.Names, c)
+			}
+		}
+		columns[strings.TrimSpace(parts[0])] = set
 	}
+	return columns, nil
 }
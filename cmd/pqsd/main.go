@@ -2,27 +2,37 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"syscall"
 	"time"
 
 	_ "net/http/pprof"
 
 	_ "golang.org/x/net/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/google/gops/agent"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/tmc/pqstream"
 	"github.com/tmc/pqstream/ctxutil"
 	"github.com/tmc/pqstream/pqs"
+	"github.com/tmc/pqstream/tlsutil"
 
 	_ "github.com/kardianos/minwinsvc" // import minwinsvc for windows service support
 )
@@ -31,14 +41,39 @@ var (
 	verbose         = flag.Bool("v", false, "be verbose")
 	postgresCluster = flag.String("connect", "", "postgresql cluster address")
 	tableRegexp     = flag.String("tables", ".*", "regexp of tables to manage")
+	schemaRegexp    = flag.String("schemas", "^public$", "regexp of schemas whose tables are managed")
 	remove          = flag.Bool("remove", false, "if true, remove triggers and exit")
 	grpcAddr        = flag.String("addr", ":7000", "listen addr")
 	debugAddr       = flag.String("debugaddr", ":7001", "listen debug addr")
 	redactions      = flag.String("redactions", "", "details of fields to redact in JSON format i.e '{\"public\":{\"users\":[\"password\",\"ssn\"]}}'")
+	redactionsFile  = flag.String("redactions-file", "", "file of fields to redact in JSON or YAML format, reloaded on SIGHUP or when it changes")
+	redactInTrigger = flag.Bool("redact-in-trigger", false, "if true, columns removed by the redactions are left out of notifications by the triggers")
+	redactionKey    = flag.String("redaction-key-file", "", "file holding the secret key used by redactions with the hash strategy")
+	profiles        = flag.String("redaction-profiles", "", "named redactions clients can request in JSON format i.e '{\"partner\":{\"public\":{\"users\":[\"email\"]}}}'")
+	source          = flag.String("source", sourceNotify, "where changes are read from: 'notify' (triggers) or 'logical' (logical replication slot)")
+	slot            = flag.String("slot", "pqstream", "logical replication slot to consume when -source=logical")
+	outbox          = flag.Bool("outbox", false, "if true, triggers record changes in an outbox table, where they are kept while pqsd is not running or no client is connected; entries are deleted once queued for connected clients")
+	payloadTable    = flag.Bool("payload-table", false, "if true, changes too large for a notification are passed through a table rather than read back from the row")
+	commitTrigger   = flag.Bool("commit-trigger", false, "if true, a deferred trigger reports when each transaction commits so clients requesting transactions receive them right away")
+	queueSize       = flag.Int("queue-size", 1024, "number of events queued for each client, 0 for no limit")
+	overflow        = flag.String("overflow", "block", "what to do when a client's queue is full: 'block' all clients, 'drop-oldest' queued events or 'disconnect' the client")
+	reconcile       = flag.Duration("reconcile", time.Minute, "how often to check for new tables to watch")
+	tlsCert         = flag.String("tls-cert", "", "if set, serve TLS with this PEM certificate, reloaded when the file changes")
+	tlsKey          = flag.String("tls-key", "", "PEM key of -tls-cert")
+	tlsClientCA     = flag.String("tls-client-ca", "", "if set, require clients to present a certificate signed by a CA in this PEM bundle")
+	principals      = flag.String("principals", "", "if set, a JSON file of the tokens and client certificates allowed to subscribe and the tables granted to each")
 )
 
 const (
 	gracefulStopMaxWait = 10 * time.Second
+	// how often the redactions file is checked for changes
+	redactionsPollInterval = 5 * time.Second
+
+	// name of the PQStream service for health checks
+	pqsServiceName = "pqs.PQStream"
+
+	sourceNotify  = "notify"
+	sourceLogical = "logical"
 )
 
 func main() {
@@ -67,19 +102,100 @@ func run(ctx context.Context) error {
 		return err
 	}
 
+	schemaRe, err := regexp.Compile(*schemaRegexp)
+	if err != nil {
+		return err
+	}
+
+	overflowPolicy, err := pqstream.ParseOverflowPolicy(*overflow)
+	if err != nil {
+		return err
+	}
+
+	healthServer := health.NewServer()
+	setServingStatus := func(err error) {
+		status := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+			log.Println("not serving:", err)
+		}
+		healthServer.SetServingStatus("", status)
+		healthServer.SetServingStatus(pqsServiceName, status)
+	}
+	setServingStatus(errors.New("starting"))
+
 	opts := []pqstream.ServerOption{
+		pqstream.WithHealthObserver(setServingStatus),
+		pqstream.WithSubscriberQueue(*queueSize, overflowPolicy),
 		pqstream.WithTableRegexp(tableRe),
+		pqstream.WithSchemaRegexp(schemaRe),
+		pqstream.WithTableReconcileInterval(*reconcile),
+	}
+
+	switch *source {
+	case sourceNotify:
+		if *outbox {
+			opts = append(opts, pqstream.WithOutbox())
+		}
+		if *payloadTable {
+			opts = append(opts, pqstream.WithPayloadTable())
+		}
+		if *redactInTrigger {
+			opts = append(opts, pqstream.WithTriggerRedactions())
+		}
+		if *commitTrigger {
+			opts = append(opts, pqstream.WithCommitTrigger())
+		}
+	case sourceLogical:
+		opts = append(opts, pqstream.WithLogicalReplication(*slot))
+	default:
+		return errors.Errorf("unknown source %q", *source)
 	}
 
 	if (len(*redactions)) > 0 {
-		rfields, err := pqstream.DecodeRedactions(*redactions)
+		rfields, err := pqstream.ParseRedactions(*redactions)
 		if err != nil {
 			return errors.Wrap(err, "decoding redactions")
 		}
 
 		if len(rfields) > 0 {
-			opts = append(opts, pqstream.WithFieldRedactions(rfields))
+			opts = append(opts, pqstream.WithRedactions(rfields))
+		}
+	}
+	if len(*redactions) > 0 && *redactionsFile != "" {
+		return errors.New("-redactions and -redactions-file are mutually exclusive")
+	}
+	if *redactionKey != "" {
+		key, err := ioutil.ReadFile(*redactionKey)
+		if err != nil {
+			return errors.Wrap(err, "redaction key")
 		}
+		opts = append(opts, pqstream.WithRedactionKey(bytes.TrimSpace(key)))
+	}
+	if len(*profiles) > 0 {
+		p, err := pqstream.DecodeRedactionProfiles(*profiles)
+		if err != nil {
+			return errors.Wrap(err, "decoding redaction profiles")
+		}
+		opts = append(opts, pqstream.WithRedactionProfiles(p))
+	}
+	if *principals != "" {
+		f, err := os.Open(*principals)
+		if err != nil {
+			return errors.Wrap(err, "principals")
+		}
+		p, err := pqstream.DecodePrincipals(f)
+		f.Close()
+		if err != nil {
+			return errors.Wrap(err, "decoding principals")
+		}
+		for _, pr := range p {
+			if pr.Token != "" && *tlsCert == "" {
+				// tokens would otherwise be sent in the clear.
+				return errors.Errorf("principal %s authenticates with a token, which requires -tls-cert", pr.Name)
+			}
+		}
+		opts = append(opts, pqstream.WithPrincipals(p))
 	}
 	if *verbose {
 		l := logrus.New()
@@ -92,11 +208,25 @@ func run(ctx context.Context) error {
 		return err
 	}
 
-	err = errors.Wrap(server.RemoveTriggers(), "RemoveTriggers")
-	if err != nil || *remove {
+	if *remove {
+		err = errors.Wrap(server.RemoveTriggers(), "RemoveTriggers")
+		if err == nil && *source == sourceLogical {
+			err = errors.Wrap(server.RemoveReplicationSlot(), "RemoveReplicationSlot")
+		}
 		return err
 	}
 
+	if *redactionsFile != "" {
+		r, err := pqstream.LoadRedactionsFile(*redactionsFile)
+		if err != nil {
+			return err
+		}
+		if err := server.SetRedactions(r); err != nil {
+			return errors.Wrap(err, "redactions")
+		}
+		go watchRedactions(ctx, server, *redactionsFile)
+	}
+
 	if err = server.InstallTriggers(); err != nil {
 		return errors.Wrap(err, "InstallTriggers")
 	}
@@ -108,10 +238,36 @@ func run(ctx context.Context) error {
 		}
 	}()
 
-	s := grpc.NewServer()
+	// serves pprof, x/net/trace, metrics and health checks
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := server.Health(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	go http.ListenAndServe(*debugAddr, nil)
+
+	grpcOpts := []grpc.ServerOption{grpc.StreamInterceptor(server.StreamInterceptor())}
+	if *tlsCert != "" || *tlsKey != "" {
+		cfg, err := tlsutil.ServerConfig(*tlsCert, *tlsKey, *tlsClientCA)
+		if err != nil {
+			return errors.Wrap(err, "tls")
+		}
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(cfg)))
+	} else if *tlsClientCA != "" {
+		return errors.New("-tls-client-ca requires -tls-cert and -tls-key")
+	}
+	s := grpc.NewServer(grpcOpts...)
 	pqs.RegisterPQStreamServer(s, server)
+	healthpb.RegisterHealthServer(s, healthServer)
 	go func() {
 		<-ctx.Done()
+		healthServer.Shutdown()
 		s.GracefulStop()
 		<-time.After(gracefulStopMaxWait)
 		s.Stop()
@@ -126,3 +282,45 @@ func run(ctx context.Context) error {
 	}
 	return err
 }
+
+// watchRedactions reloads the redactions file on SIGHUP or when it changes. If it is invalid the previous
+// redactions stay in effect.
+func watchRedactions(ctx context.Context, server *pqstream.Server, name string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	t := time.NewTicker(redactionsPollInterval)
+	defer t.Stop()
+	modTime := fileModTime(name)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-t.C:
+			m := fileModTime(name)
+			if m.Equal(modTime) {
+				continue
+			}
+			modTime = m
+		}
+		r, err := pqstream.LoadRedactionsFile(name)
+		if err == nil {
+			err = server.SetRedactions(r)
+		}
+		if err != nil {
+			log.Println("keeping previous redactions:", err)
+			continue
+		}
+		log.Println("reloaded redactions from", name)
+	}
+}
+
+// fileModTime returns the modification time of a file, or the zero time if it cannot be read.
+func fileModTime(name string) time.Time {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
@@ -16,12 +16,15 @@ import (
 	"github.com/google/gops/agent"
 	_ "golang.org/x/net/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/golang/protobuf/jsonpb"
 	_ "github.com/kardianos/minwinsvc" // import minwinsvc for windows service support
 	"github.com/pkg/errors"
 	"github.com/tmc/pqstream/ctxutil"
 	"github.com/tmc/pqstream/pqs"
+	"github.com/tmc/pqstream/tlsutil"
 )
 
 var (
@@ -30,6 +33,11 @@ var (
 	debugAddr     = flag.String("debugaddr", ":7001", "listen debug addr")
 	activeMqAddr  = flag.String("amqaddr", "localhost:61613", "ActiveMq server to send messages to")
 	actvieMqQueue = flag.String("amqqueue", "/queue/test", "ActiveMq queue to send messages to")
+	useTLS        = flag.Bool("tls", false, "if true, connect with TLS verifying pqsd with the system CAs, implied by the other -tls flags")
+	tlsCA         = flag.String("tls-ca", "", "PEM bundle of CAs to verify pqsd with")
+	tlsCert       = flag.String("tls-cert", "", "PEM client certificate to present to pqsd")
+	tlsKey        = flag.String("tls-key", "", "PEM key of -tls-cert")
+	token         = flag.String("token", "", "bearer token to authenticate to pqsd with")
 )
 
 func main() {
@@ -48,7 +56,14 @@ func run(ctx context.Context) error {
 		return err
 	}
 
-	conn, err := grpc.DialContext(ctx, *pqsdAddr, grpc.WithInsecure())
+	creds, err := transportCredentials()
+	if err != nil {
+		return err
+	}
+	if *token != "" {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.Pairs("authorization", "Bearer "+*token))
+	}
+	conn, err := grpc.DialContext(ctx, *pqsdAddr, creds)
 	if err != nil {
 		return errors.Wrap(err, "dial")
 	}
@@ -93,3 +108,15 @@ func run(ctx context.Context) error {
 		fmt.Println()
 	}
 }
+
+// transportCredentials returns the dial option securing the connection to pqsd as requested by the tls flags.
+func transportCredentials() (grpc.DialOption, error) {
+	if !*useTLS && *tlsCA == "" && *tlsCert == "" && *tlsKey == "" {
+		return grpc.WithInsecure(), nil
+	}
+	cfg, err := tlsutil.ClientConfig(tlsutil.ServerName(*pqsdAddr), *tlsCA, *tlsCert, *tlsKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "tls")
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(cfg)), nil
+}
@@ -0,0 +1,437 @@
+package pqstream
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/tmc/pqstream/pqs"
+
+	ptypes_struct "github.com/golang/protobuf/ptypes/struct"
+)
+
+// filterExpr is a compiled filter expression.
+//
+// Expressions compare event fields with literals, for example:
+//
+//	payload.status == "paid" && payload.amount > 1000
+//	op == "UPDATE" && changes.email != null
+//	table == "users" || !(payload.tags[0] == "internal")
+//
+// The fields available are schema, table, op, id, payload and changes. Fields of payload and changes
+// are selected with '.' or ["name"] and list elements with [index]; missing fields are null.
+// Comparisons between values of different types are false, and only booleans are true in && and ||.
+type filterExpr interface {
+	eval(e *pqs.Event) interface{}
+}
+
+// compileFilter parses a filter expression.
+func compileFilter(s string) (filterExpr, error) {
+	p := &filterParser{lex: &filterLexer{input: s}}
+	p.next()
+	x, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return x, nil
+}
+
+type filterLiteral struct {
+	v interface{}
+}
+
+func (l filterLiteral) eval(*pqs.Event) interface{} {
+	return l.v
+}
+
+type filterNot struct {
+	x filterExpr
+}
+
+func (n filterNot) eval(e *pqs.Event) interface{} {
+	return !truthy(n.x.eval(e))
+}
+
+type filterLogical struct {
+	and  bool
+	l, r filterExpr
+}
+
+func (l filterLogical) eval(e *pqs.Event) interface{} {
+	if truthy(l.l.eval(e)) != l.and {
+		return !l.and
+	}
+	return truthy(l.r.eval(e))
+}
+
+type filterCompare struct {
+	op   string
+	l, r filterExpr
+}
+
+func (c filterCompare) eval(e *pqs.Event) interface{} {
+	l, r := c.l.eval(e), c.r.eval(e)
+	switch c.op {
+	case "==":
+		return equal(l, r)
+	case "!=":
+		return !equal(l, r)
+	}
+	var cmp int
+	switch l := l.(type) {
+	case float64:
+		r, ok := r.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	case string:
+		r, ok := r.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return false
+	}
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// filterPath selects a field of the event, elems are string keys or int indexes.
+type filterPath struct {
+	root  string
+	elems []interface{}
+}
+
+func (p filterPath) eval(e *pqs.Event) interface{} {
+	var v interface{}
+	switch p.root {
+	case "schema":
+		v = e.Schema
+	case "table":
+		v = e.Table
+	case "op":
+		v = e.Op.String()
+	case "id":
+		v = e.Id
+	case "payload":
+		if e.Payload != nil {
+			v = e.Payload
+		}
+	case "changes":
+		if e.Changes != nil {
+			v = e.Changes
+		}
+	}
+	for _, elem := range p.elems {
+		switch c := v.(type) {
+		case *ptypes_struct.Struct:
+			key, ok := elem.(string)
+			if !ok {
+				return nil
+			}
+			v = nativeValue(c.Fields[key])
+		case *ptypes_struct.ListValue:
+			i, ok := elem.(int)
+			if !ok || i < 0 || i >= len(c.Values) {
+				return nil
+			}
+			v = nativeValue(c.Values[i])
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// nativeValue unwraps v into nil, bool, float64, string, *Struct or *ListValue.
+func nativeValue(v *ptypes_struct.Value) interface{} {
+	switch k := v.GetKind().(type) {
+	case *ptypes_struct.Value_BoolValue:
+		return k.BoolValue
+	case *ptypes_struct.Value_NumberValue:
+		return k.NumberValue
+	case *ptypes_struct.Value_StringValue:
+		return k.StringValue
+	case *ptypes_struct.Value_StructValue:
+		return k.StructValue
+	case *ptypes_struct.Value_ListValue:
+		return k.ListValue
+	}
+	return nil
+}
+
+func truthy(v interface{}) bool {
+	b, ok := v.(bool)
+	return ok && b
+}
+
+func equal(a, b interface{}) bool {
+	if a, ok := a.(proto.Message); ok {
+		b, ok := b.(proto.Message)
+		return ok && proto.Equal(a, b)
+	}
+	return a == b
+}
+
+var comparisons = map[string]bool{
+	"==": true,
+	"!=": true,
+	"<":  true,
+	"<=": true,
+	">":  true,
+	">=": true,
+}
+
+var filterRoots = map[string]bool{
+	"schema":  true,
+	"table":   true,
+	"op":      true,
+	"id":      true,
+	"payload": true,
+	"changes": true,
+}
+
+type filterParser struct {
+	lex *filterLexer
+	tok token
+}
+
+func (p *filterParser) next() {
+	p.tok = p.lex.next()
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("filter: position %d: %s", p.tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	x, err := p.parseAnd()
+	for err == nil && p.tok.is(tokOp, "||") {
+		p.next()
+		var r filterExpr
+		if r, err = p.parseAnd(); err == nil {
+			x = filterLogical{and: false, l: x, r: r}
+		}
+	}
+	return x, err
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	x, err := p.parseUnary()
+	for err == nil && p.tok.is(tokOp, "&&") {
+		p.next()
+		var r filterExpr
+		if r, err = p.parseUnary(); err == nil {
+			x = filterLogical{and: true, l: x, r: r}
+		}
+	}
+	return x, err
+}
+
+func (p *filterParser) parseUnary() (filterExpr, error) {
+	if p.tok.is(tokOp, "!") {
+		p.next()
+		x, err := p.parseUnary()
+		return filterNot{x}, err
+	}
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if op := p.tok.text; p.tok.kind == tokOp && comparisons[op] {
+		p.next()
+		r, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return filterCompare{op: op, l: l, r: r}, nil
+	}
+	return l, nil
+}
+
+func (p *filterParser) parseOperand() (filterExpr, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokString:
+		p.next()
+		return filterLiteral{tok.text}, nil
+	case tokNumber:
+		p.next()
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", tok.text)
+		}
+		return filterLiteral{f}, nil
+	case tokIdent:
+		p.next()
+		switch tok.text {
+		case "true":
+			return filterLiteral{true}, nil
+		case "false":
+			return filterLiteral{false}, nil
+		case "null":
+			return filterLiteral{nil}, nil
+		}
+		if !filterRoots[tok.text] {
+			return nil, fmt.Errorf("filter: position %d: unknown field %q", tok.pos+1, tok.text)
+		}
+		return p.parsePath(tok.text)
+	case tokOp:
+		if tok.text == "(" {
+			p.next()
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.tok.is(tokOp, ")") {
+				return nil, p.errorf("expected ')', found %s", p.tok)
+			}
+			p.next()
+			return x, nil
+		}
+	}
+	return nil, p.errorf("unexpected %s", tok)
+}
+
+func (p *filterParser) parsePath(root string) (filterExpr, error) {
+	path := filterPath{root: root}
+	for {
+		switch {
+		case p.tok.is(tokOp, "."):
+			p.next()
+			if p.tok.kind != tokIdent {
+				return nil, p.errorf("expected field name, found %s", p.tok)
+			}
+			path.elems = append(path.elems, p.tok.text)
+			p.next()
+		case p.tok.is(tokOp, "["):
+			p.next()
+			switch p.tok.kind {
+			case tokString:
+				path.elems = append(path.elems, p.tok.text)
+			case tokNumber:
+				i, err := strconv.Atoi(p.tok.text)
+				if err != nil {
+					return nil, p.errorf("invalid index %q", p.tok.text)
+				}
+				path.elems = append(path.elems, i)
+			default:
+				return nil, p.errorf("expected field name or index, found %s", p.tok)
+			}
+			p.next()
+			if !p.tok.is(tokOp, "]") {
+				return nil, p.errorf("expected ']', found %s", p.tok)
+			}
+			p.next()
+		default:
+			return path, nil
+		}
+	}
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokInvalid
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) is(kind tokenKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+type filterLexer struct {
+	input string
+	pos   int
+}
+
+func (l *filterLexer) next() token {
+	for l.pos < len(l.input) && unicode.IsSpace(rune(l.input[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: tokEOF, pos: start}
+	}
+	c := l.input[l.pos]
+	switch {
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for l.pos < len(l.input) && (l.input[l.pos] == '_' || unicode.IsLetter(rune(l.input[l.pos])) || unicode.IsDigit(rune(l.input[l.pos]))) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.input[start:l.pos], pos: start}
+	case c == '-' || unicode.IsDigit(rune(c)):
+		l.pos++
+		for l.pos < len(l.input) && strings.IndexByte("0123456789.eE+-", l.input[l.pos]) >= 0 {
+			if (l.input[l.pos] == '+' || l.input[l.pos] == '-') && l.input[l.pos-1] != 'e' && l.input[l.pos-1] != 'E' {
+				break
+			}
+			l.pos++
+		}
+		return token{kind: tokNumber, text: l.input[start:l.pos], pos: start}
+	case c == '"' || c == '\'':
+		l.pos++
+		var b bytes.Buffer
+		for l.pos < len(l.input) && l.input[l.pos] != c {
+			if l.input[l.pos] == '\\' && l.pos+1 < len(l.input) {
+				l.pos++
+			}
+			b.WriteByte(l.input[l.pos])
+			l.pos++
+		}
+		if l.pos >= len(l.input) {
+			return token{kind: tokInvalid, text: l.input[start:], pos: start}
+		}
+		l.pos++
+		return token{kind: tokString, text: b.String(), pos: start}
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", "."} {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, text: op, pos: start}
+		}
+	}
+	l.pos++
+	return token{kind: tokInvalid, text: l.input[start:l.pos], pos: start}
+}
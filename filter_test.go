@@ -0,0 +1,68 @@
+package pqstream
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/tmc/pqstream/pqs"
+)
+
+func Test_compileFilter(t *testing.T) {
+	e := &pqs.Event{}
+	if err := jsonpb.UnmarshalString(`{
+		"schema": "public",
+		"table": "orders",
+		"op": "UPDATE",
+		"id": "7",
+		"payload": {"status": "paid", "amount": 1500, "tags": ["rush", "gift"], "customer": {"name": "o'brien"}, "note": null},
+		"changes": {"status": "pending"}
+	}`, e); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		expr    string
+		want    bool
+		wantErr bool
+	}{
+		{`payload.status == "paid" && payload.amount > 1000`, true, false},
+		{`payload.status == "paid" && payload.amount > 2000`, false, false},
+		{`payload.amount >= 1500 && payload.amount <= 1500`, true, false},
+		{`payload.amount < 1e3 || payload.amount != -1`, true, false},
+		{`op == "UPDATE" && changes.status != null`, true, false},
+		{`op == 'DELETE'`, false, false},
+		{`schema == "public" && table == "orders" && id == "7"`, true, false},
+		{`payload.tags[1] == "gift" && payload.tags[2] == null`, true, false},
+		{`payload["customer"].name == 'o\'brien'`, true, false},
+		{`payload.note == null && payload.missing == null`, true, false},
+		{`!(payload.status == "paid")`, false, false},
+		{`payload.status`, false, false},
+		{`payload.status > 1`, false, false},
+		{`payload.status < "q"`, true, false},
+		{`payload.amount == "1500"`, false, false},
+		{`changes == payload`, false, false},
+		{`true`, true, false},
+		{``, false, true},
+		{`payload.status ==`, false, true},
+		{`row.status == "paid"`, false, true},
+		{`(payload.status == "paid"`, false, true},
+		{`payload.status == "paid`, false, true},
+		{`payload. == 1`, false, true},
+		{`payload[true] == 1`, false, true},
+		{`payload.status = "paid"`, false, true},
+		{`payload.amount > 1 1`, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := compileFilter(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compileFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := truthy(f.eval(e)); got != tt.want {
+				t.Errorf("compileFilter().eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
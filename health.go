@@ -0,0 +1,83 @@
+package pqstream
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// WithHealthObserver registers fn to be called whenever the result of Health changes.
+func WithHealthObserver(fn func(err error)) ServerOption {
+	return func(s *Server) {
+		s.health.observer = fn
+	}
+}
+
+// Health reports whether the server is able to stream changes. It returns an error describing the problem
+// until the triggers are installed and HandleEvents is running, while the database listener is disconnected
+// and while new tables cannot be watched.
+func (s *Server) Health() error {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	return s.health.err()
+}
+
+// healthStatus tracks the conditions that must hold for the server to stream changes.
+type healthStatus struct {
+	mu       sync.Mutex
+	observer func(error)
+	last     error
+
+	connected bool
+	installed bool
+	handling  bool
+	pingErr   error
+	// new tables that could not be watched
+	unwatched []string
+}
+
+// err returns the first unmet condition, if any.
+func (h *healthStatus) err() error {
+	switch {
+	case !h.installed:
+		return errors.New("triggers not installed")
+	case !h.handling:
+		return errors.New("not handling events")
+	case !h.connected:
+		return errors.New("listener disconnected")
+	case h.pingErr != nil:
+		return errors.Wrap(h.pingErr, "listener ping")
+	case len(h.unwatched) > 0:
+		return errors.Errorf("new tables not watched: %s", strings.Join(h.unwatched, ", "))
+	}
+	return nil
+}
+
+// update applies fn and notifies the observer if the health changed as a result.
+func (h *healthStatus) update(fn func(h *healthStatus)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fn(h)
+	err := h.err()
+	if (err == nil) == (h.last == nil) && (err == nil || err.Error() == h.last.Error()) {
+		return
+	}
+	h.last = err
+	if h.observer != nil {
+		h.observer(err)
+	}
+}
+
+// listenerEvent records the connection state reported by the database listener.
+func (h *healthStatus) listenerEvent(ev pq.ListenerEventType) {
+	h.update(func(h *healthStatus) {
+		switch ev {
+		case pq.ListenerEventConnected, pq.ListenerEventReconnected:
+			h.connected, h.pingErr = true, nil
+		case pq.ListenerEventDisconnected, pq.ListenerEventConnectionAttemptFailed:
+			h.connected = false
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package pqstream
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func Test_healthStatus(t *testing.T) {
+	var observed []error
+	h := &healthStatus{observer: func(err error) { observed = append(observed, err) }}
+	tests := []struct {
+		name    string
+		fn      func(h *healthStatus)
+		wantErr bool
+		notify  bool
+	}{
+		{"connected", func(h *healthStatus) { h.listenerEvent(pq.ListenerEventConnected) }, true, true},
+		{"installed", func(h *healthStatus) { h.update(func(h *healthStatus) { h.installed = true }) }, true, true},
+		{"handling", func(h *healthStatus) { h.update(func(h *healthStatus) { h.handling = true }) }, false, true},
+		{"ping ok", func(h *healthStatus) { h.update(func(h *healthStatus) { h.pingErr = nil }) }, false, false},
+		{"disconnected", func(h *healthStatus) { h.listenerEvent(pq.ListenerEventDisconnected) }, true, true},
+		{"attempt failed", func(h *healthStatus) { h.listenerEvent(pq.ListenerEventConnectionAttemptFailed) }, true, false},
+		{"reconnected", func(h *healthStatus) { h.listenerEvent(pq.ListenerEventReconnected) }, false, true},
+		{"ping failed", func(h *healthStatus) { h.update(func(h *healthStatus) { h.pingErr = errors.New("broken") }) }, true, true},
+		{"ping recovered", func(h *healthStatus) { h.update(func(h *healthStatus) { h.pingErr = nil }) }, false, true},
+		{"unwatched", func(h *healthStatus) { h.update(func(h *healthStatus) { h.unwatched = []string{"public.a"} }) }, true, true},
+		{"still unwatched", func(h *healthStatus) { h.update(func(h *healthStatus) { h.unwatched = []string{"public.a"} }) }, true, false},
+		{"watched", func(h *healthStatus) { h.update(func(h *healthStatus) { h.unwatched = nil }) }, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := len(observed)
+			tt.fn(h)
+			if err := h.err(); (err != nil) != tt.wantErr {
+				t.Errorf("err() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if notified := len(observed) > n; notified != tt.notify {
+				t.Errorf("observer notified = %v, want %v", notified, tt.notify)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package pqstream
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/tmc/pqstream/pqs"
+
+	ptypes_struct "github.com/golang/protobuf/ptypes/struct"
+)
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// primaryKey returns the primary key columns of t in key order, or nothing if it has no primary key.
+func primaryKey(q queryer, t table) ([]string, error) {
+	rows, err := q.Query(sqlQueryPrimaryKey, t.quoted())
+	if err != nil {
+		return nil, errors.Wrap(err, "query primary key")
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, errors.Wrap(err, "primary key scan")
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+// rowKey returns the values of the key columns in row, or nil if there are no key columns.
+func rowKey(row *ptypes_struct.Struct, columns []string) *ptypes_struct.Struct {
+	if row == nil || len(columns) == 0 {
+		return nil
+	}
+	key := &ptypes_struct.Struct{Fields: make(map[string]*ptypes_struct.Value, len(columns))}
+	for _, c := range columns {
+		if v, ok := row.Fields[c]; ok {
+			key.Fields[c] = v
+		}
+	}
+	return key
+}
+
+// triggerArguments renders column names as a list of SQL string literals, i.e. the arguments of the notify trigger.
+func triggerArguments(columns []string) string {
+	args := make([]string, len(columns))
+	for i, c := range columns {
+		args[i] = "'" + strings.Replace(c, "'", "''", -1) + "'"
+	}
+	return strings.Join(args, ", ")
+}
+
+// lookupKey returns the key used to fetch the row of e, falling back to its id column if the event has no key.
+func lookupKey(e *pqs.Event) *ptypes_struct.Struct {
+	if len(e.Key.GetFields()) > 0 {
+		return e.Key
+	}
+	if e.Id == "" {
+		return nil
+	}
+	// the id is the text of a json value, strings are quoted.
+	id := &ptypes_struct.Value{}
+	if err := jsonpb.UnmarshalString(e.Id, id); err != nil {
+		id.Kind = &ptypes_struct.Value_StringValue{StringValue: e.Id}
+	}
+	return &ptypes_struct.Struct{Fields: map[string]*ptypes_struct.Value{"id": id}}
+}
+
+// fetchRowByKeyQuery returns the query that selects the row of t with key.
+func fetchRowByKeyQuery(t table, key *ptypes_struct.Struct) string {
+	columns := make([]string, 0, len(key.Fields))
+	for c := range key.Fields {
+		columns = append(columns, c)
+	}
+	sort.Strings(columns)
+	for i, c := range columns {
+		columns[i] = pq.QuoteIdentifier(c)
+	}
+	return fmt.Sprintf(sqlFetchRowByKey, t.quoted(), strings.Join(columns, ", "))
+}
@@ -0,0 +1,70 @@
+package pqstream
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/tmc/pqstream/pqs"
+)
+
+func Test_lookupKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		want  string
+		query string
+	}{
+		{"none", `{"table":"notes"}`, "", ""},
+		{"integer_id", `{"schema":"public","table":"notes","id":"1"}`, `{"id":1}`,
+			`"public"."notes" WHERE ("id") = (SELECT "id" FROM json_populate_record(NULL::"public"."notes", $1::json))`},
+		{"text_id", `{"schema":"public","table":"notes","id":"\"a\""}`, `{"id":"a"}`,
+			`"public"."notes" WHERE ("id") = (SELECT "id" FROM json_populate_record(NULL::"public"."notes", $1::json))`},
+		{"key", `{"schema":"public","table":"items","id":"1","key":{"uid":"7d0c"}}`, `{"uid":"7d0c"}`,
+			`"public"."items" WHERE ("uid") = (SELECT "uid" FROM json_populate_record(NULL::"public"."items", $1::json))`},
+		{"composite_key", `{"schema":"sales","table":"order items","key":{"order_id":3,"line":2}}`, `{"line":2,"order_id":3}`,
+			`"sales"."order items" WHERE ("line", "order_id") = (SELECT "line", "order_id" FROM json_populate_record(NULL::"sales"."order items", $1::json))`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &pqs.Event{}
+			if err := jsonpb.UnmarshalString(tt.event, e); err != nil {
+				t.Fatal(err)
+			}
+			key := lookupKey(e)
+			if key == nil {
+				if tt.want != "" {
+					t.Errorf("lookupKey() = nil, want %v", tt.want)
+				}
+				return
+			}
+			got, err := (&jsonpb.Marshaler{}).MarshalToString(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("lookupKey() = %v, want %v", got, tt.want)
+			}
+			q := fetchRowByKeyQuery(table{e.Schema, e.Table}, key)
+			if !strings.Contains(q, tt.query) {
+				t.Errorf("fetchRowByKeyQuery() = %v, want it to contain %v", q, tt.query)
+			}
+		})
+	}
+}
+
+func Test_triggerArguments(t *testing.T) {
+	tests := []struct {
+		columns []string
+		want    string
+	}{
+		{nil, ""},
+		{[]string{"id"}, `'id'`},
+		{[]string{"order_id", "it's"}, `'order_id', 'it''s'`},
+	}
+	for _, tt := range tests {
+		if got := triggerArguments(tt.columns); got != tt.want {
+			t.Errorf("triggerArguments(%v) = %v, want %v", tt.columns, got, tt.want)
+		}
+	}
+}
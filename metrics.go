@@ -0,0 +1,69 @@
+package pqstream
+
+import (
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tmc/pqstream/pqs"
+)
+
+// Metrics are registered with the default prometheus registry.
+var (
+	eventsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pqstream",
+		Name:      "events_received_total",
+		Help:      "Changes received from the database.",
+	}, []string{"schema", "table", "op"})
+	eventsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pqstream",
+		Name:      "events_sent_total",
+		Help:      "Events sent to subscribers, counted once per subscriber, by the subscriber's principal.",
+	}, []string{"schema", "table", "op", "principal"})
+	eventsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "pqstream",
+		Name:      "events_dropped_total",
+		Help:      "Events dropped because a subscriber's queue was full.",
+	})
+	fallbackLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pqstream",
+		Name:      "fallback_lookups_total",
+		Help:      "Rows read back from the database for changes too large for a notification.",
+	}, []string{"schema", "table", "result"})
+	patchErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pqstream",
+		Name:      "patch_errors_total",
+		Help:      "Updates for which no changes could be generated.",
+	}, []string{"schema", "table"})
+	listenerEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pqstream",
+		Name:      "listener_events_total",
+		Help:      "State changes of the database notification listener, i.e. reconnects.",
+	}, []string{"event"})
+	subscriberCount = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pqstream",
+		Name:      "subscribers",
+		Help:      "Number of connected subscribers.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(eventsReceived, eventsSent, eventsDropped, fallbackLookups, patchErrors, listenerEvents, subscriberCount)
+}
+
+var listenerEventNames = map[pq.ListenerEventType]string{
+	pq.ListenerEventConnected:               "connected",
+	pq.ListenerEventDisconnected:            "disconnected",
+	pq.ListenerEventReconnected:             "reconnected",
+	pq.ListenerEventConnectionAttemptFailed: "connection_attempt_failed",
+}
+
+func eventLabels(e *pqs.Event) prometheus.Labels {
+	return prometheus.Labels{"schema": e.Schema, "table": e.Table, "op": e.Op.String()}
+}
+
+// sentLabels labels an event sent to a subscriber authenticated as principal, which is empty if principals are
+// not configured. Subscribers are told apart by principal only, so the number of series stays bounded.
+func sentLabels(e *pqs.Event, principal string) prometheus.Labels {
+	l := eventLabels(e)
+	l["principal"] = principal
+	return l
+}
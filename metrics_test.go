@@ -0,0 +1,34 @@
+package pqstream
+
+import (
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/tmc/pqstream/pqs"
+)
+
+func Test_listenerEventNames(t *testing.T) {
+	for _, ev := range []pq.ListenerEventType{
+		pq.ListenerEventConnected,
+		pq.ListenerEventDisconnected,
+		pq.ListenerEventReconnected,
+		pq.ListenerEventConnectionAttemptFailed,
+	} {
+		if listenerEventNames[ev] == "" {
+			t.Errorf("listenerEventNames has no name for %v", ev)
+		}
+	}
+}
+
+func Test_eventLabels(t *testing.T) {
+	got := eventLabels(&pqs.Event{Schema: "public", Table: "notes", Op: pqs.Operation_UPDATE})
+	if got["schema"] != "public" || got["table"] != "notes" || got["op"] != "UPDATE" {
+		t.Errorf("eventLabels() = %v", got)
+	}
+	if _, err := eventsReceived.GetMetricWith(got); err != nil {
+		t.Errorf("eventsReceived.GetMetricWith(eventLabels()) error = %v", err)
+	}
+	if _, err := eventsSent.GetMetricWith(sentLabels(&pqs.Event{}, "billing")); err != nil {
+		t.Errorf("eventsSent.GetMetricWith(sentLabels()) error = %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package pqstream
+
+import (
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/tmc/pqstream/pqs"
+)
+
+// outboxBatchSize is the number of transactions read from the outbox at once.
+const outboxBatchSize = 500
+
+// WithOutbox configures the triggers to record changes in an outbox table rather than sending them with NOTIFY.
+//
+// Changes are kept in the outbox while the server is stopped or disconnected and while no subscriber is
+// connected, and are delivered once one is. Entries are deleted once their events have been queued for the
+// connected subscribers, so events still queued when the server stops are lost to those subscribers.
+func WithOutbox() ServerOption {
+	return func(s *Server) {
+		s.outbox = true
+	}
+}
+
+// drainOutbox delivers the events recorded in the outbox until it is empty, one transaction at a time.
+// Nothing is read while there are no subscribers, as the entries would be deleted without being received.
+func (s *Server) drainOutbox(subscribers map[*subscription]bool) error {
+	for len(subscribers) > 0 {
+		n, err := s.drainOutboxBatch(subscribers)
+		if err != nil || n < outboxBatchSize {
+			return err
+		}
+	}
+	return nil
+}
+
+// drainOutboxBatch delivers the events of up to outboxBatchSize transactions and returns the number of
+// transactions read.
+func (s *Server) drainOutboxBatch(subscribers map[*subscription]bool) (int, error) {
+	rows, err := s.db.Query(sqlReadOutbox, outboxBatchSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "read outbox")
+	}
+	defer rows.Close()
+	var (
+		ids []int64
+		// transactions read, the entries of each are consecutive
+		n    int
+		txid int64
+	)
+	for rows.Next() {
+		var (
+			id           int64
+			notification string
+		)
+		if err := rows.Scan(&id, &notification); err != nil {
+			return len(ids), errors.Wrap(err, "outbox scan")
+		}
+		ids = append(ids, id)
+		re := &pqs.RawEvent{}
+		if err := jsonpb.UnmarshalString(notification, re); err != nil {
+			s.logger.WithField("outbox-id", id).WithError(err).Errorln("discarding invalid outbox entry")
+			continue
+		}
+		if n == 0 || re.Txid != txid {
+			n, txid = n+1, re.Txid
+		}
+		s.handleRawEvent(subscribers, re)
+	}
+	if err := rows.Err(); err != nil {
+		return n, errors.Wrap(err, "read outbox")
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	// whole transactions are read, so one without a COMMIT entry has ended too. The events are sent before
+	// the entries are deleted.
+	s.finishTransaction(subscribers, nil)
+	if err := s.flush(subscribers, s.savePosition); err != nil {
+		return n, err
+	}
+	if _, err := s.db.Exec(sqlDeleteOutbox, pq.Array(ids)); err != nil {
+		return n, errors.Wrap(err, "delete outbox")
+	}
+	return n, nil
+}
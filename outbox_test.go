@@ -0,0 +1,131 @@
+package pqstream
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tmc/pqstream/pqs"
+)
+
+func TestServer_drainOutbox(t *testing.T) {
+	db := dbOrSkip(t)
+	cs, cleanup := testDBConn(t, db, "outbox")
+	defer cleanup()
+	s, err := NewServer(cs, WithLogger(loggerFromT(t)), WithOutbox())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.InstallTriggers(); err != nil {
+		t.Fatal(err)
+	}
+
+	// changes made while no events are being handled are retained.
+	const nInserts = 3
+	for i := 0; i < nInserts; i++ {
+		if _, err := s.db.Exec(testInsert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// and kept while there is no subscriber.
+	if err := s.drainOutbox(map[*subscription]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	var kept int
+	if err := s.db.QueryRow("select count(*) from pqstream_outbox").Scan(&kept); err != nil {
+		t.Fatal(err)
+	}
+	if kept != nInserts {
+		t.Errorf("outbox has %v entries after drainOutbox() without subscribers, want %v", kept, nInserts)
+	}
+	got := make(chan *pqs.Event, nInserts)
+	subscribers := map[*subscription]bool{
+		{fn: func(e *pqs.Event) bool {
+			if !isBoundary(e) {
+				got <- e
+			}
+			return true
+		}}: true,
+	}
+	if err := s.drainOutbox(subscribers); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != nInserts {
+		t.Errorf("drainOutbox() delivered %v events, want %v", len(got), nInserts)
+	}
+	for i := 0; i < len(got); i++ {
+		if e := <-got; e.Op != pqs.Operation_INSERT || e.Table != "notes" {
+			t.Errorf("drainOutbox() delivered %v, want an insert into notes", e)
+		}
+	}
+	var remaining int
+	if err := s.db.QueryRow("select count(*) from pqstream_outbox").Scan(&remaining); err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Errorf("outbox has %v entries after drainOutbox(), want 0", remaining)
+	}
+
+	// a new server continues the stream, so clients can resume across restarts.
+	restarted, err := NewServer(cs, WithLogger(loggerFromT(t)), WithOutbox())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+	if err := restarted.InstallTriggers(); err != nil {
+		t.Fatal(err)
+	}
+	if restarted.epoch != s.epoch || restarted.position != s.position {
+		t.Errorf("restarted server at %v/%v, want %v/%v", restarted.epoch, restarted.position, s.epoch, s.position)
+	}
+
+	if err := s.RemoveTriggers(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("select 1 from pqstream_outbox"); err == nil {
+		t.Error("outbox still exists after RemoveTriggers()")
+	}
+}
+
+func TestServer_drainOutbox_interleaved(t *testing.T) {
+	db := dbOrSkip(t)
+	cs, cleanup := testDBConn(t, db, "outbox_interleaved")
+	defer cleanup()
+	s, err := NewServer(cs, WithLogger(loggerFromT(t)), WithOutbox())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.InstallTriggers(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.RemoveTriggers()
+
+	// entries are numbered as they are written, so those of concurrent transactions interleave.
+	insert := func(txid, seq int) string {
+		return fmt.Sprintf(`{"schema":"public","table":"notes","op":"INSERT","txid":%d,"sequence":%d,"payload":{"id":%d}}`, txid, seq, seq)
+	}
+	commit := func(txid, seq int) string {
+		return fmt.Sprintf(`{"op":"COMMIT","txid":%d,"sequence":%d,"commit_time":"1970-01-01T00:00:%02dZ"}`, txid, seq, txid)
+	}
+	for _, n := range []string{insert(1, 1), insert(2, 1), insert(1, 2), commit(2, 1), commit(1, 2)} {
+		if _, err := s.db.Exec("insert into pqstream_outbox (notification) values ($1)", n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	subscribers := map[*subscription]bool{
+		{fn: func(e *pqs.Event) bool {
+			got = append(got, eventSummary(e))
+			return true
+		}}: true,
+	}
+	if err := s.drainOutbox(subscribers); err != nil {
+		t.Fatal(err)
+	}
+	want := "BEGIN:2 INSERT:2#1 COMMIT:2#1@2 BEGIN:1 INSERT:1#1 INSERT:1#2 COMMIT:1#2@1"
+	if strings.Join(got, " ") != want {
+		t.Errorf("drainOutbox() sent %v, want %v", strings.Join(got, " "), want)
+	}
+}
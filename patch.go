@@ -2,10 +2,17 @@ package pqstream
 
 import (
 	"bytes"
+	"sort"
+	"strings"
 
 	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
 	ptypes_struct "github.com/golang/protobuf/ptypes/struct"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/tmc/pqstream/pqs"
 )
 
 func generatePatch(a, b *ptypes_struct.Struct) (*ptypes_struct.Struct, error) {
@@ -38,3 +45,97 @@ func generatePatch(a, b *ptypes_struct.Struct) (*ptypes_struct.Struct, error) {
 	err = (&jsonpb.Unmarshaler{}).Unmarshal(rbytes, r)
 	return r, err
 }
+
+// changeFormatter returns a function that represents the changes of UPDATE events in the format requested
+// by r and leaves out the previous row. An unknown format results in an InvalidArgument error.
+func changeFormatter(r *pqs.ListenRequest) (func(*pqs.Event) *pqs.Event, error) {
+	format := r.ChangeFormat
+	if _, ok := pqs.ChangeFormat_name[int32(format)]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown change_format %d", format)
+	}
+	return func(e *pqs.Event) *pqs.Event {
+		if e.Previous == nil && format == pqs.ChangeFormat_MERGE_PATCH {
+			return e
+		}
+		f := *e
+		f.Previous = nil
+		f.ChangeFormat = format
+		if e.Op != pqs.Operation_UPDATE {
+			return &f
+		}
+		switch format {
+		case pqs.ChangeFormat_JSON_PATCH:
+			f.Changes = nil
+			f.Patch = jsonPatch(e.Previous, e.Payload)
+		case pqs.ChangeFormat_COLUMN_VALUES:
+			f.Changes = nil
+			f.ColumnChanges = columnChanges(e.Previous, e.Payload)
+		}
+		return &f
+	}, nil
+}
+
+// jsonPointerEscaper escapes the reference tokens of JSON pointers, see RFC6901.
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// jsonPatch returns the RFC6902 operations that turn a into b. Objects are compared field by field while other
+// values, including lists, are replaced as a whole. If a is not known every field of b is added, which replaces
+// the existing value of a field.
+func jsonPatch(a, b *ptypes_struct.Struct) []*pqs.PatchOperation {
+	if b == nil {
+		return nil
+	}
+	return diffFields(nil, "", a.GetFields(), b.Fields)
+}
+
+// diffFields appends the operations that turn the fields a into b, found at prefix, to ops.
+func diffFields(ops []*pqs.PatchOperation, prefix string, a, b map[string]*ptypes_struct.Value) []*pqs.PatchOperation {
+	for _, name := range fieldNames(a, b) {
+		path := prefix + "/" + jsonPointerEscaper.Replace(name)
+		av, inA := a[name]
+		bv, inB := b[name]
+		switch {
+		case !inB:
+			ops = append(ops, &pqs.PatchOperation{Op: "remove", Path: path})
+		case !inA:
+			ops = append(ops, &pqs.PatchOperation{Op: "add", Path: path, Value: bv})
+		case proto.Equal(av, bv):
+		case av.GetStructValue() != nil && bv.GetStructValue() != nil:
+			ops = diffFields(ops, path, av.GetStructValue().Fields, bv.GetStructValue().Fields)
+		default:
+			ops = append(ops, &pqs.PatchOperation{Op: "replace", Path: path, Value: bv})
+		}
+	}
+	return ops
+}
+
+// columnChanges returns the previous and new values of the columns that differ between a and b.
+func columnChanges(a, b *ptypes_struct.Struct) map[string]*pqs.ColumnChange {
+	if b == nil {
+		return nil
+	}
+	changes := make(map[string]*pqs.ColumnChange)
+	for _, name := range fieldNames(a.GetFields(), b.Fields) {
+		av, bv := a.GetFields()[name], b.Fields[name]
+		if av != nil && bv != nil && proto.Equal(av, bv) {
+			continue
+		}
+		changes[name] = &pqs.ColumnChange{Old: av, New: bv}
+	}
+	return changes
+}
+
+// fieldNames returns the names of the fields in either a or b in sorted order.
+func fieldNames(a, b map[string]*ptypes_struct.Value) []string {
+	names := make([]string, 0, len(b))
+	for name := range a {
+		if _, ok := b[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for name := range b {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
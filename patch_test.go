@@ -6,6 +6,9 @@ import (
 	"github.com/golang/protobuf/jsonpb"
 	ptypes_struct "github.com/golang/protobuf/ptypes/struct"
 	"github.com/google/go-cmp/cmp"
+	"github.com/tmc/pqstream/pqs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func Test_generatePatch(t *testing.T) {
@@ -48,3 +51,63 @@ func Test_generatePatch(t *testing.T) {
 		})
 	}
 }
+
+func Test_changeFormatter(t *testing.T) {
+	const (
+		update = `{"schema":"public","table":"users","op":"UPDATE","id":"1","payload":{"email":null,"id":1,"name":"a","profile":{"a/b":2,"tags":["x"]}},"changes":{"email":"old@b.c","name":"b","nick":"c","profile":{"a/b":1,"tags":["x","y"]}},"previous":{"email":"old@b.c","id":1,"name":"b","nick":"c","profile":{"a/b":1,"tags":["x","y"]}}}`
+		insert = `{"schema":"public","table":"users","op":"INSERT","id":"1","payload":{"id":1}}`
+		// the previous row of large changes may not be known.
+		unknown = `{"schema":"public","table":"users","op":"UPDATE","id":"1","payload":{"id":1},"changes":{"id":null}}`
+	)
+	tests := []struct {
+		name   string
+		format pqs.ChangeFormat
+		event  string
+		want   string
+	}{
+		{"merge_patch", pqs.ChangeFormat_MERGE_PATCH, update,
+			`{"schema":"public","table":"users","op":"UPDATE","id":"1","payload":{"email":null,"id":1,"name":"a","profile":{"a/b":2,"tags":["x"]}},"changes":{"email":"old@b.c","name":"b","nick":"c","profile":{"a/b":1,"tags":["x","y"]}}}`},
+		{"merge_patch_insert", pqs.ChangeFormat_MERGE_PATCH, insert, insert},
+		{"json_patch", pqs.ChangeFormat_JSON_PATCH, update,
+			`{"schema":"public","table":"users","op":"UPDATE","id":"1","payload":{"email":null,"id":1,"name":"a","profile":{"a/b":2,"tags":["x"]}},"changeFormat":"JSON_PATCH","patch":[` +
+				`{"op":"replace","path":"/email","value":null},` +
+				`{"op":"replace","path":"/name","value":"a"},` +
+				`{"op":"remove","path":"/nick"},` +
+				`{"op":"replace","path":"/profile/a~1b","value":2},` +
+				`{"op":"replace","path":"/profile/tags","value":["x"]}]}`},
+		{"json_patch_insert", pqs.ChangeFormat_JSON_PATCH, insert,
+			`{"schema":"public","table":"users","op":"INSERT","id":"1","payload":{"id":1},"changeFormat":"JSON_PATCH"}`},
+		{"json_patch_unknown", pqs.ChangeFormat_JSON_PATCH, unknown,
+			`{"schema":"public","table":"users","op":"UPDATE","id":"1","payload":{"id":1},"changeFormat":"JSON_PATCH","patch":[{"op":"add","path":"/id","value":1}]}`},
+		{"column_values", pqs.ChangeFormat_COLUMN_VALUES, update,
+			`{"schema":"public","table":"users","op":"UPDATE","id":"1","payload":{"email":null,"id":1,"name":"a","profile":{"a/b":2,"tags":["x"]}},"changeFormat":"COLUMN_VALUES","columnChanges":{` +
+				`"email":{"old":"old@b.c","new":null},` +
+				`"name":{"old":"b","new":"a"},` +
+				`"nick":{"old":"c"},` +
+				`"profile":{"old":{"a/b":1,"tags":["x","y"]},"new":{"a/b":2,"tags":["x"]}}}}`},
+		{"column_values_unknown", pqs.ChangeFormat_COLUMN_VALUES, unknown,
+			`{"schema":"public","table":"users","op":"UPDATE","id":"1","payload":{"id":1},"changeFormat":"COLUMN_VALUES","columnChanges":{"id":{"new":1}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &pqs.Event{}
+			if err := jsonpb.UnmarshalString(tt.event, e); err != nil {
+				t.Fatal(err)
+			}
+			format, err := changeFormatter(&pqs.ListenRequest{ChangeFormat: tt.format})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := (&jsonpb.Marshaler{}).MarshalToString(applyShared(t, format, e))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("changeFormatter() = %v, want %v\n%s", got, tt.want, cmp.Diff(got, tt.want))
+			}
+		})
+	}
+	if _, err := changeFormatter(&pqs.ListenRequest{ChangeFormat: 42}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("changeFormatter() with unknown format error = %v, want InvalidArgument", err)
+	}
+}
@@ -0,0 +1,48 @@
+package pqstream
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/pkg/errors"
+	"github.com/tmc/pqstream/pqs"
+)
+
+// payloadRetention is how long stored payloads are kept before they are assumed to be orphaned,
+// i.e. because their notification was sent while the server was not listening.
+const payloadRetention = time.Hour
+
+// payloadExpiryInterval is how often stored payloads are checked for expiry.
+const payloadExpiryInterval = time.Minute
+
+// WithPayloadTable configures the triggers to store changes that are too large for a notification in a table
+// and notify only a token for them.
+//
+// The stored change is read and deleted when the notification is handled, so large events keep the payload
+// and changes as of the change rather than being read back from the table afterwards. As each stored change
+// is delivered once, only a single server should use the payload table of a database.
+func WithPayloadTable() ServerOption {
+	return func(s *Server) {
+		s.payloadTable = true
+	}
+}
+
+// takePayload replaces re with the full event stored under its token and removes it from the payload table.
+func (s *Server) takePayload(re *pqs.RawEvent) error {
+	var notification string
+	if err := s.db.QueryRow(sqlTakePayload, re.Token).Scan(&notification); err != nil {
+		return errors.Wrap(err, "take payload")
+	}
+	full := &pqs.RawEvent{}
+	if err := jsonpb.UnmarshalString(notification, full); err != nil {
+		return errors.Wrap(err, "payload unmarshal")
+	}
+	*re = *full
+	return nil
+}
+
+// expirePayloads removes stored payloads older than payloadRetention.
+func (s *Server) expirePayloads() error {
+	_, err := s.db.Exec(sqlExpirePayloads, payloadRetention.Seconds())
+	return errors.Wrap(err, "expire payloads")
+}
@@ -0,0 +1,69 @@
+package pqstream
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tmc/pqstream/pqs"
+)
+
+func TestServer_takePayload(t *testing.T) {
+	db := dbOrSkip(t)
+	cs, cleanup := testDBConn(t, db, "payloads")
+	defer cleanup()
+	s, err := NewServer(cs, WithLogger(loggerFromT(t)), WithPayloadTable())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.InstallTriggers(); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 9000
+	got := make(chan *pqs.Event, 1)
+	subscribers := map[*subscription]bool{
+		{fn: func(e *pqs.Event) bool {
+			if !isBoundary(e) {
+				got <- e
+			}
+			return true
+		}}: true,
+	}
+	for _, q := range []string{
+		fmt.Sprintf(testInsertTemplate, mkString(n, '.')),
+		fmt.Sprintf(testUpdateTemplate, mkString(n, '-')),
+		"delete from notes where id=1",
+	} {
+		if _, err := s.db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+		// the change is sent once the notification marking its commit is handled.
+		var e *pqs.Event
+		for e == nil {
+			select {
+			case e = <-got:
+			case ev := <-s.l.NotificationChannel():
+				if err := s.handleEvent(subscribers, ev); err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no event for %.20s", q)
+			}
+		}
+		if note := e.GetPayload().GetFields()["note"].GetStringValue(); len(note) < n {
+			t.Errorf("%v event has a note of %v bytes, want at least %v", e.Op, len(note), n)
+		}
+		if e.Op == pqs.Operation_UPDATE && e.GetChanges().GetFields()["note"] == nil {
+			t.Errorf("update event has changes %v, want the previous note", e.Changes)
+		}
+	}
+	var remaining int
+	if err := s.db.QueryRow("select count(*) from pqstream_payloads").Scan(&remaining); err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Errorf("payload table has %v entries, want 0", remaining)
+	}
+}
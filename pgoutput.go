@@ -0,0 +1,348 @@
+package pqstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/pkg/errors"
+	"github.com/tmc/pqstream/pqs"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+
+	ptypes_struct "github.com/golang/protobuf/ptypes/struct"
+)
+
+// postgres type oids that map onto non-string json values.
+const (
+	oidBool    = 16
+	oidInt8    = 20
+	oidInt2    = 21
+	oidInt4    = 23
+	oidOID     = 26
+	oidJSON    = 114
+	oidFloat4  = 700
+	oidFloat8  = 701
+	oidNumeric = 1700
+	oidJSONB   = 3802
+)
+
+type relationColumn struct {
+	name string
+	oid  uint32
+	key  bool
+}
+
+type relation struct {
+	schema  string
+	table   string
+	columns []relationColumn
+}
+
+// pgoutputDecoder decodes messages of the pgoutput logical replication protocol (version 1).
+//
+// Relation messages are cached so that subsequent row messages can be mapped onto column names.
+type pgoutputDecoder struct {
+	relations map[uint32]*relation
+	// xid, commit time and number of changes so far of the transaction being decoded
+	xid        uint32
+	commitTime *timestamp.Timestamp
+	sequence   int64
+}
+
+func newPgoutputDecoder() *pgoutputDecoder {
+	return &pgoutputDecoder{
+		relations: make(map[uint32]*relation),
+	}
+}
+
+// decode decodes a single pgoutput message into zero or more events.
+func (d *pgoutputDecoder) decode(data []byte) ([]*pqs.RawEvent, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty message")
+	}
+	r := &messageReader{buf: data[1:]}
+	switch data[0] {
+	case 'B':
+		r.uint64() // final lsn
+		d.commitTime = pgTimestamp(r.uint64())
+		d.xid = r.uint32()
+		d.sequence = 0
+		if r.err != nil {
+			return nil, r.err
+		}
+		return []*pqs.RawEvent{{Op: pqs.Operation_BEGIN, Txid: int64(d.xid), CommitTime: d.commitTime}}, nil
+	case 'C':
+		return []*pqs.RawEvent{{Op: pqs.Operation_COMMIT, Txid: int64(d.xid), Sequence: d.sequence, CommitTime: d.commitTime}}, nil
+	case 'R':
+		return nil, d.decodeRelation(r)
+	case 'I':
+		rel, err := d.relation(r.uint32())
+		if err != nil {
+			return nil, err
+		}
+		if kind := r.byte(); kind != 'N' {
+			return nil, fmt.Errorf("insert: unexpected tuple kind %q", kind)
+		}
+		payload, unchanged := d.decodeTuple(r, rel)
+		return []*pqs.RawEvent{d.event(rel, pqs.Operation_INSERT, payload, nil, unchanged)}, r.err
+	case 'U':
+		rel, err := d.relation(r.uint32())
+		if err != nil {
+			return nil, err
+		}
+		var previous *ptypes_struct.Struct
+		kind := r.byte()
+		// the old row is sent in full with REPLICA IDENTITY FULL. Otherwise at most its key is, with the other
+		// columns null, which is not the previous row.
+		if kind == 'K' || kind == 'O' {
+			old, _ := d.decodeTuple(r, rel)
+			if kind == 'O' {
+				previous = old
+			}
+			kind = r.byte()
+		}
+		if kind != 'N' {
+			return nil, fmt.Errorf("update: unexpected tuple kind %q", kind)
+		}
+		payload, unchanged := d.decodeTuple(r, rel)
+		unknown := copyUnchanged(payload, previous, unchanged)
+		return []*pqs.RawEvent{d.event(rel, pqs.Operation_UPDATE, payload, previous, unknown)}, r.err
+	case 'D':
+		rel, err := d.relation(r.uint32())
+		if err != nil {
+			return nil, err
+		}
+		if kind := r.byte(); kind != 'K' && kind != 'O' {
+			return nil, fmt.Errorf("delete: unexpected tuple kind %q", kind)
+		}
+		payload, unchanged := d.decodeTuple(r, rel)
+		return []*pqs.RawEvent{d.event(rel, pqs.Operation_DELETE, payload, nil, unchanged)}, r.err
+	case 'T':
+		n := int(r.uint32())
+		r.byte() // options
+		var events []*pqs.RawEvent
+		for i := 0; i < n && r.err == nil; i++ {
+			rel, err := d.relation(r.uint32())
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, d.event(rel, pqs.Operation_TRUNCATE, nil, nil, nil))
+		}
+		return events, r.err
+	default:
+		// origin, type and logical decoding messages carry nothing we stream.
+		return nil, nil
+	}
+}
+
+// key returns the names of the columns that are part of the replica identity key.
+func (rel *relation) key() []string {
+	var columns []string
+	for _, c := range rel.columns {
+		if c.key {
+			columns = append(columns, c.name)
+		}
+	}
+	return columns
+}
+
+func (d *pgoutputDecoder) relation(id uint32) (*relation, error) {
+	rel, ok := d.relations[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown relation %d", id)
+	}
+	return rel, nil
+}
+
+func (d *pgoutputDecoder) decodeRelation(r *messageReader) error {
+	id := r.uint32()
+	rel := &relation{
+		schema: r.string(),
+		table:  r.string(),
+	}
+	r.byte() // replica identity setting
+	n := int(r.uint16())
+	for i := 0; i < n && r.err == nil; i++ {
+		c := relationColumn{key: r.byte()&1 != 0}
+		c.name = r.string()
+		c.oid = r.uint32()
+		r.uint32() // type modifier
+		rel.columns = append(rel.columns, c)
+	}
+	if r.err != nil {
+		return errors.Wrap(r.err, "relation")
+	}
+	d.relations[id] = rel
+	return nil
+}
+
+// decodeTuple decodes TupleData into a struct keyed by column name.
+// Unchanged toasted values are omitted as their contents are not sent, their columns are returned instead.
+func (d *pgoutputDecoder) decodeTuple(r *messageReader, rel *relation) (*ptypes_struct.Struct, []string) {
+	var unchanged []string
+	n := int(r.uint16())
+	s := &ptypes_struct.Struct{Fields: make(map[string]*ptypes_struct.Value, n)}
+	for i := 0; i < n && r.err == nil; i++ {
+		var c relationColumn
+		if i < len(rel.columns) {
+			c = rel.columns[i]
+		} else {
+			c.name = fmt.Sprint(i)
+		}
+		switch r.byte() {
+		case 'n':
+			s.Fields[c.name] = &ptypes_struct.Value{Kind: &ptypes_struct.Value_NullValue{}}
+		case 'u':
+			unchanged = append(unchanged, c.name)
+		case 't':
+			s.Fields[c.name] = textValue(c.oid, r.bytes(int(r.uint32())))
+		default:
+			r.err = errors.New("unknown tuple column kind")
+		}
+	}
+	return s, unchanged
+}
+
+// copyUnchanged copies the values of the unchanged columns of an update from the previous row into payload,
+// if it is known. It returns the columns whose value is not known.
+func copyUnchanged(payload, previous *ptypes_struct.Struct, unchanged []string) []string {
+	var unknown []string
+	for _, c := range unchanged {
+		if v, ok := previous.GetFields()[c]; ok {
+			payload.Fields[c] = v
+			continue
+		}
+		unknown = append(unknown, c)
+	}
+	return unknown
+}
+
+func (d *pgoutputDecoder) event(rel *relation, op pqs.Operation, payload, previous *ptypes_struct.Struct, unknown []string) *pqs.RawEvent {
+	e := &pqs.RawEvent{
+		Schema:         rel.schema,
+		Table:          rel.table,
+		Op:             op,
+		Payload:        payload,
+		Previous:       previous,
+		Txid:           int64(d.xid),
+		UnknownColumns: unknown,
+	}
+	if d.xid != 0 {
+		d.sequence++
+		e.Sequence = d.sequence
+		e.CommitTime = d.commitTime
+	}
+	if payload != nil {
+		if id, ok := payload.Fields["id"]; ok {
+			e.Id = valueText(id)
+		}
+		e.Key = rowKey(payload, rel.key())
+	}
+	return e
+}
+
+// pgTimestamp converts a protocol timestamp, microseconds since 2000-01-01 UTC, into a Timestamp.
+func pgTimestamp(us uint64) *timestamp.Timestamp {
+	const pgEpoch = 946684800 // 2000-01-01 in unix seconds
+	t := int64(us)
+	sec, usec := t/1e6, t%1e6
+	if usec < 0 {
+		sec, usec = sec-1, usec+1e6
+	}
+	return &timestamp.Timestamp{Seconds: pgEpoch + sec, Nanos: int32(usec * 1000)}
+}
+
+// textValue converts the text representation of a column into the value row_to_json would produce.
+func textValue(oid uint32, text []byte) *ptypes_struct.Value {
+	switch oid {
+	case oidBool:
+		return &ptypes_struct.Value{Kind: &ptypes_struct.Value_BoolValue{BoolValue: string(text) == "t"}}
+	case oidInt2, oidInt4, oidInt8, oidOID, oidFloat4, oidFloat8, oidNumeric, oidJSON, oidJSONB:
+		v := &ptypes_struct.Value{}
+		if err := jsonpb.Unmarshal(bytes.NewReader(text), v); err == nil {
+			return v
+		}
+	}
+	return &ptypes_struct.Value{Kind: &ptypes_struct.Value_StringValue{StringValue: string(text)}}
+}
+
+// valueText renders v in the same way as a json value cast to text in postgres.
+func valueText(v *ptypes_struct.Value) string {
+	if n, ok := v.Kind.(*ptypes_struct.Value_NumberValue); ok {
+		return strconv.FormatFloat(n.NumberValue, 'f', -1, 64)
+	}
+	s, err := (&jsonpb.Marshaler{}).MarshalToString(v)
+	if err != nil {
+		return ""
+	}
+	return s
+}
+
+// messageReader reads big endian protocol values, recording the first error encountered.
+type messageReader struct {
+	buf []byte
+	err error
+}
+
+func (r *messageReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.buf) < n {
+		r.err = errors.New("short message")
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *messageReader) byte() byte {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *messageReader) uint16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *messageReader) uint32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *messageReader) uint64() uint64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// string reads a null terminated string.
+func (r *messageReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.buf, 0)
+	if i < 0 {
+		r.err = errors.New("unterminated string")
+		return ""
+	}
+	s := string(r.buf[:i])
+	r.buf = r.buf[i+1:]
+	return s
+}
@@ -0,0 +1,170 @@
+package pqstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/tmc/pqstream/pqs"
+)
+
+// message helps build pgoutput protocol messages.
+type message struct {
+	bytes.Buffer
+}
+
+func newMessage(kind byte) *message {
+	m := &message{}
+	m.WriteByte(kind)
+	return m
+}
+
+func (m *message) u16(v uint16) *message {
+	binary.Write(m, binary.BigEndian, v)
+	return m
+}
+
+func (m *message) u32(v uint32) *message {
+	binary.Write(m, binary.BigEndian, v)
+	return m
+}
+
+func (m *message) str(s string) *message {
+	m.WriteString(s)
+	m.WriteByte(0)
+	return m
+}
+
+func (m *message) b(c byte) *message {
+	m.WriteByte(c)
+	return m
+}
+
+// tuple appends TupleData, a nil value is encoded as null and "\x00u" as an unchanged toasted value.
+func (m *message) tuple(values ...interface{}) *message {
+	m.u16(uint16(len(values)))
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			m.b('n')
+		case byte:
+			m.b(v)
+		case string:
+			m.b('t').u32(uint32(len(v)))
+			m.WriteString(v)
+		}
+	}
+	return m
+}
+
+func notesRelation() []byte {
+	return newMessage('R').u32(42).str("public").str("notes").b('d').u16(4).
+		b(1).str("id").u32(oidInt4).u32(0).
+		b(0).str("note").u32(25).u32(0).
+		b(0).str("done").u32(oidBool).u32(0).
+		b(0).str("meta").u32(oidJSONB).u32(0).
+		Bytes()
+}
+
+func Test_pgoutputDecoder_decode(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages [][]byte
+		want     []string
+		wantErr  bool
+	}{
+		{"empty", [][]byte{{}}, nil, true},
+		{"begin", [][]byte{newMessage('B').u32(0).u32(1).u32(0).u32(2).u32(7).Bytes()}, []string{
+			`{"op":"BEGIN","txid":"7","commitTime":"2000-01-01T00:00:00.000002Z"}`,
+		}, false},
+		{"short_begin", [][]byte{newMessage('B').Bytes()}, nil, true},
+		{"unknown_relation", [][]byte{newMessage('I').u32(42).b('N').tuple("1").Bytes()}, nil, true},
+		{"insert", [][]byte{
+			notesRelation(),
+			newMessage('I').u32(42).b('N').tuple("1", "a note", "t", `{"a":[1]}`).Bytes(),
+		}, []string{
+			`{"schema":"public","table":"notes","op":"INSERT","id":"1","payload":{"done":true,"id":1,"meta":{"a":[1]},"note":"a note"},"key":{"id":1}}`,
+		}, false},
+		{"insert_in_transaction", [][]byte{
+			notesRelation(),
+			newMessage('B').u32(0).u32(1).u32(0).u32(2).u32(7).Bytes(),
+			newMessage('I').u32(42).b('N').tuple("1", "a note", "t", nil).Bytes(),
+			newMessage('I').u32(42).b('N').tuple("2", "another note", "f", nil).Bytes(),
+			newMessage('C').b(0).u32(0).u32(1).u32(0).u32(3).u32(0).u32(2).Bytes(),
+		}, []string{
+			`{"op":"BEGIN","txid":"7","commitTime":"2000-01-01T00:00:00.000002Z"}`,
+			`{"schema":"public","table":"notes","op":"INSERT","id":"1","payload":{"done":true,"id":1,"meta":null,"note":"a note"},"txid":"7","key":{"id":1},"sequence":"1","commitTime":"2000-01-01T00:00:00.000002Z"}`,
+			`{"schema":"public","table":"notes","op":"INSERT","id":"2","payload":{"done":false,"id":2,"meta":null,"note":"another note"},"txid":"7","key":{"id":2},"sequence":"2","commitTime":"2000-01-01T00:00:00.000002Z"}`,
+			`{"op":"COMMIT","txid":"7","sequence":"2","commitTime":"2000-01-01T00:00:00.000002Z"}`,
+		}, false},
+		{"update", [][]byte{
+			notesRelation(),
+			newMessage('U').u32(42).b('O').tuple("1", "a note", "f", nil).b('N').tuple("1", "changed", "f", byte('u')).Bytes(),
+		}, []string{
+			`{"schema":"public","table":"notes","op":"UPDATE","id":"1","payload":{"done":false,"id":1,"meta":null,"note":"changed"},"previous":{"done":false,"id":1,"meta":null,"note":"a note"},"key":{"id":1}}`,
+		}, false},
+		{"update_unchanged_unknown", [][]byte{
+			notesRelation(),
+			newMessage('U').u32(42).b('K').tuple("1", nil, nil, nil).b('N').tuple("1", "changed", "f", byte('u')).Bytes(),
+		}, []string{
+			`{"schema":"public","table":"notes","op":"UPDATE","id":"1","payload":{"done":false,"id":1,"note":"changed"},"key":{"id":1},"unknownColumns":["meta"]}`,
+		}, false},
+		{"update_without_previous", [][]byte{
+			notesRelation(),
+			newMessage('U').u32(42).b('N').tuple("1", "changed", "f", nil).Bytes(),
+		}, []string{
+			`{"schema":"public","table":"notes","op":"UPDATE","id":"1","payload":{"done":false,"id":1,"meta":null,"note":"changed"},"key":{"id":1}}`,
+		}, false},
+		{"delete_key", [][]byte{
+			notesRelation(),
+			newMessage('D').u32(42).b('K').tuple("1", nil, nil, nil).Bytes(),
+		}, []string{
+			`{"schema":"public","table":"notes","op":"DELETE","id":"1","payload":{"done":null,"id":1,"meta":null,"note":null},"key":{"id":1}}`,
+		}, false},
+		{"truncate", [][]byte{
+			notesRelation(),
+			newMessage('T').u32(1).b(0).u32(42).Bytes(),
+		}, []string{
+			`{"schema":"public","table":"notes","op":"TRUNCATE"}`,
+		}, false},
+		{"short", [][]byte{
+			notesRelation(),
+			newMessage('I').u32(42).b('N').u16(1).b('t').u32(10).Bytes(),
+		}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newPgoutputDecoder()
+			var (
+				got []string
+				err error
+			)
+			for _, m := range tt.messages {
+				var events []*pqs.RawEvent
+				if events, err = d.decode(m); err != nil {
+					break
+				}
+				for _, e := range events {
+					s, err := (&jsonpb.Marshaler{}).MarshalToString(e)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got = append(got, s)
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("pgoutputDecoder.decode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("pgoutputDecoder.decode() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("pgoutputDecoder.decode()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -9,6 +9,9 @@ It is generated from these files:
 
 It has these top-level messages:
 	ListenRequest
+	ColumnSet
+	PatchOperation
+	ColumnChange
 	RawEvent
 	Event
 */
@@ -18,6 +21,7 @@ import proto "github.com/golang/protobuf/proto"
 import fmt "fmt"
 import math "math"
 import google_protobuf "github.com/golang/protobuf/ptypes/struct"
+import google_protobuf1 "github.com/golang/protobuf/ptypes/timestamp"
 
 import (
 	context "golang.org/x/net/context"
@@ -44,6 +48,11 @@ const (
 	Operation_UPDATE   Operation = 2
 	Operation_DELETE   Operation = 3
 	Operation_TRUNCATE Operation = 4
+	// an existing row read while bootstrapping a stream.
+	Operation_SNAPSHOT Operation = 5
+	// transaction boundaries, only sent if requested.
+	Operation_BEGIN  Operation = 6
+	Operation_COMMIT Operation = 7
 )
 
 var Operation_name = map[int32]string{
@@ -52,6 +61,9 @@ var Operation_name = map[int32]string{
 	2: "UPDATE",
 	3: "DELETE",
 	4: "TRUNCATE",
+	5: "SNAPSHOT",
+	6: "BEGIN",
+	7: "COMMIT",
 }
 var Operation_value = map[string]int32{
 	"UNKNOWN":  0,
@@ -59,6 +71,9 @@ var Operation_value = map[string]int32{
 	"UPDATE":   2,
 	"DELETE":   3,
 	"TRUNCATE": 4,
+	"SNAPSHOT": 5,
+	"BEGIN":    6,
+	"COMMIT":   7,
 }
 
 func (x Operation) String() string {
@@ -66,10 +81,70 @@ func (x Operation) String() string {
 }
 func (Operation) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
 
+// How the changes of UPDATE events are represented.
+type ChangeFormat int32
+
+const (
+	// changes is an RFC7386 JSON merge patch that turns payload into the
+	// previous row.
+	ChangeFormat_MERGE_PATCH ChangeFormat = 0
+	// patch holds the RFC6902 JSON Patch operations that turn the previous row
+	// into payload.
+	ChangeFormat_JSON_PATCH ChangeFormat = 1
+	// column_changes holds the previous and new value of each changed column.
+	ChangeFormat_COLUMN_VALUES ChangeFormat = 2
+)
+
+var ChangeFormat_name = map[int32]string{
+	0: "MERGE_PATCH",
+	1: "JSON_PATCH",
+	2: "COLUMN_VALUES",
+}
+var ChangeFormat_value = map[string]int32{
+	"MERGE_PATCH":   0,
+	"JSON_PATCH":    1,
+	"COLUMN_VALUES": 2,
+}
+
+func (x ChangeFormat) String() string {
+	return proto.EnumName(ChangeFormat_name, int32(x))
+}
+func (ChangeFormat) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
 // A request to listen to database event streams.
 type ListenRequest struct {
 	// if provided, this string will be used to match table names to track.
 	TableRegexp string `protobuf:"bytes,1,opt,name=table_regexp,json=tableRegexp" json:"table_regexp,omitempty"`
+	// if provided, events after this position that are still held by the
+	// server are sent before live events. resume_epoch must then be the epoch
+	// of the event at this position.
+	ResumeFrom uint64 `protobuf:"varint,2,opt,name=resume_from,json=resumeFrom" json:"resume_from,omitempty"`
+	// if provided, only events with one of these operations are sent.
+	Ops []Operation `protobuf:"varint,3,rep,packed,name=ops,enum=pqs.Operation" json:"ops,omitempty"`
+	// if provided, this string will be used to match schema names to track.
+	SchemaRegexp string `protobuf:"bytes,4,opt,name=schema_regexp,json=schemaRegexp" json:"schema_regexp,omitempty"`
+	// if provided, only events for which this expression is true are sent,
+	// i.e. 'payload.status == "paid" && payload.amount > 1000'.
+	Filter string `protobuf:"bytes,5,opt,name=filter" json:"filter,omitempty"`
+	// if provided, payload and changes of events for the keyed tables only
+	// include the listed columns and id. Keys are table names, optionally
+	// qualified with the schema ("schema.table").
+	Columns map[string]*ColumnSet `protobuf:"bytes,6,rep,name=columns" json:"columns,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// if true, the events of each transaction are held back until it commits
+	// and sent between a BEGIN and a COMMIT event.
+	Transactions bool `protobuf:"varint,7,opt,name=transactions" json:"transactions,omitempty"`
+	// if provided, fields listed in these redaction profiles configured on the
+	// server are removed from events, in addition to those redacted for all
+	// clients or for the caller.
+	RedactionProfiles []string `protobuf:"bytes,8,rep,name=redaction_profiles,json=redactionProfiles" json:"redaction_profiles,omitempty"`
+	// the representation of the changes of UPDATE events, a merge patch if
+	// not provided.
+	ChangeFormat ChangeFormat `protobuf:"varint,9,opt,name=change_format,json=changeFormat,enum=pqs.ChangeFormat" json:"change_format,omitempty"`
+	// the epoch of the events resume_from refers to. If the server has another
+	// epoch, as it has restarted since without reading changes from an outbox or
+	// a replication slot, resuming fails with OUT_OF_RANGE and the client has to
+	// start over, i.e. with a snapshot.
+	ResumeEpoch string `protobuf:"bytes,10,opt,name=resume_epoch,json=resumeEpoch" json:"resume_epoch,omitempty"`
 }
 
 func (m *ListenRequest) Reset()                    { *m = ListenRequest{} }
@@ -84,6 +159,150 @@ func (m *ListenRequest) GetTableRegexp() string {
 	return ""
 }
 
+func (m *ListenRequest) GetResumeFrom() uint64 {
+	if m != nil {
+		return m.ResumeFrom
+	}
+	return 0
+}
+
+func (m *ListenRequest) GetOps() []Operation {
+	if m != nil {
+		return m.Ops
+	}
+	return nil
+}
+
+func (m *ListenRequest) GetSchemaRegexp() string {
+	if m != nil {
+		return m.SchemaRegexp
+	}
+	return ""
+}
+
+func (m *ListenRequest) GetFilter() string {
+	if m != nil {
+		return m.Filter
+	}
+	return ""
+}
+
+func (m *ListenRequest) GetColumns() map[string]*ColumnSet {
+	if m != nil {
+		return m.Columns
+	}
+	return nil
+}
+
+func (m *ListenRequest) GetTransactions() bool {
+	if m != nil {
+		return m.Transactions
+	}
+	return false
+}
+
+func (m *ListenRequest) GetRedactionProfiles() []string {
+	if m != nil {
+		return m.RedactionProfiles
+	}
+	return nil
+}
+
+func (m *ListenRequest) GetChangeFormat() ChangeFormat {
+	if m != nil {
+		return m.ChangeFormat
+	}
+	return ChangeFormat_MERGE_PATCH
+}
+
+func (m *ListenRequest) GetResumeEpoch() string {
+	if m != nil {
+		return m.ResumeEpoch
+	}
+	return ""
+}
+
+// A set of column names.
+type ColumnSet struct {
+	Names []string `protobuf:"bytes,1,rep,name=names" json:"names,omitempty"`
+}
+
+func (m *ColumnSet) Reset()                    { *m = ColumnSet{} }
+func (m *ColumnSet) String() string            { return proto.CompactTextString(m) }
+func (*ColumnSet) ProtoMessage()               {}
+func (*ColumnSet) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *ColumnSet) GetNames() []string {
+	if m != nil {
+		return m.Names
+	}
+	return nil
+}
+
+// An RFC6902 JSON Patch operation.
+type PatchOperation struct {
+	// op is one of "add", "remove" or "replace".
+	Op string `protobuf:"bytes,1,opt,name=op" json:"op,omitempty"`
+	// path is the JSON pointer of the changed value.
+	Path string `protobuf:"bytes,2,opt,name=path" json:"path,omitempty"`
+	// value is the new value, unset for "remove".
+	Value *google_protobuf.Value `protobuf:"bytes,3,opt,name=value" json:"value,omitempty"`
+}
+
+func (m *PatchOperation) Reset()                    { *m = PatchOperation{} }
+func (m *PatchOperation) String() string            { return proto.CompactTextString(m) }
+func (*PatchOperation) ProtoMessage()               {}
+func (*PatchOperation) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *PatchOperation) GetOp() string {
+	if m != nil {
+		return m.Op
+	}
+	return ""
+}
+
+func (m *PatchOperation) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *PatchOperation) GetValue() *google_protobuf.Value {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+// The values of a changed column.
+type ColumnChange struct {
+	// old is the previous value, unset if the column was added or the previous
+	// row is not known.
+	Old *google_protobuf.Value `protobuf:"bytes,1,opt,name=old" json:"old,omitempty"`
+	// new is the new value, unset if the column was removed.
+	New *google_protobuf.Value `protobuf:"bytes,2,opt,name=new" json:"new,omitempty"`
+}
+
+func (m *ColumnChange) Reset()                    { *m = ColumnChange{} }
+func (m *ColumnChange) String() string            { return proto.CompactTextString(m) }
+func (*ColumnChange) ProtoMessage()               {}
+func (*ColumnChange) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *ColumnChange) GetOld() *google_protobuf.Value {
+	if m != nil {
+		return m.Old
+	}
+	return nil
+}
+
+func (m *ColumnChange) GetNew() *google_protobuf.Value {
+	if m != nil {
+		return m.New
+	}
+	return nil
+}
+
 // RawEvent is an internal type.
 type RawEvent struct {
 	Schema   string                  `protobuf:"bytes,1,opt,name=schema" json:"schema,omitempty"`
@@ -92,12 +311,20 @@ type RawEvent struct {
 	Id       string                  `protobuf:"bytes,4,opt,name=id" json:"id,omitempty"`
 	Payload  *google_protobuf.Struct `protobuf:"bytes,5,opt,name=payload" json:"payload,omitempty"`
 	Previous *google_protobuf.Struct `protobuf:"bytes,6,opt,name=previous" json:"previous,omitempty"`
+	Txid     int64                   `protobuf:"varint,7,opt,name=txid" json:"txid,omitempty"`
+	Key      *google_protobuf.Struct `protobuf:"bytes,8,opt,name=key" json:"key,omitempty"`
+	// if set, the full event is stored in the payload table under this token.
+	Token          int64                       `protobuf:"varint,9,opt,name=token" json:"token,omitempty"`
+	Sequence       int64                       `protobuf:"varint,10,opt,name=sequence" json:"sequence,omitempty"`
+	CommitTime     *google_protobuf1.Timestamp `protobuf:"bytes,11,opt,name=commit_time,json=commitTime" json:"commit_time,omitempty"`
+	ChangeTime     *google_protobuf1.Timestamp `protobuf:"bytes,12,opt,name=change_time,json=changeTime" json:"change_time,omitempty"`
+	UnknownColumns []string                    `protobuf:"bytes,13,rep,name=unknown_columns,json=unknownColumns" json:"unknown_columns,omitempty"`
 }
 
 func (m *RawEvent) Reset()                    { *m = RawEvent{} }
 func (m *RawEvent) String() string            { return proto.CompactTextString(m) }
 func (*RawEvent) ProtoMessage()               {}
-func (*RawEvent) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+func (*RawEvent) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
 
 func (m *RawEvent) GetSchema() string {
 	if m != nil {
@@ -141,6 +368,55 @@ func (m *RawEvent) GetPrevious() *google_protobuf.Struct {
 	return nil
 }
 
+func (m *RawEvent) GetTxid() int64 {
+	if m != nil {
+		return m.Txid
+	}
+	return 0
+}
+
+func (m *RawEvent) GetKey() *google_protobuf.Struct {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *RawEvent) GetToken() int64 {
+	if m != nil {
+		return m.Token
+	}
+	return 0
+}
+
+func (m *RawEvent) GetSequence() int64 {
+	if m != nil {
+		return m.Sequence
+	}
+	return 0
+}
+
+func (m *RawEvent) GetCommitTime() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.CommitTime
+	}
+	return nil
+}
+
+func (m *RawEvent) GetChangeTime() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.ChangeTime
+	}
+	return nil
+}
+
+func (m *RawEvent) GetUnknownColumns() []string {
+	if m != nil {
+		return m.UnknownColumns
+	}
+	return nil
+}
+
 // A database event.
 type Event struct {
 	Schema string    `protobuf:"bytes,1,opt,name=schema" json:"schema,omitempty"`
@@ -150,14 +426,62 @@ type Event struct {
 	Id string `protobuf:"bytes,4,opt,name=id" json:"id,omitempty"`
 	// payload is a json encoded representation of the changed object.
 	Payload *google_protobuf.Struct `protobuf:"bytes,5,opt,name=payload" json:"payload,omitempty"`
-	// changes is, in the event of op==UPDATE an RFC7386 JSON merge patch.
+	// changes is, in the event of op==UPDATE and change_format==MERGE_PATCH,
+	// an RFC7386 JSON merge patch. It is unset if the previous row is not
+	// known.
 	Changes *google_protobuf.Struct `protobuf:"bytes,6,opt,name=changes" json:"changes,omitempty"`
+	// position is a monotonically increasing sequence number assigned by the
+	// server which may be supplied as resume_from when reconnecting.
+	Position uint64 `protobuf:"varint,7,opt,name=position" json:"position,omitempty"`
+	// txid is the id of the transaction that made the change.
+	Txid int64 `protobuf:"varint,8,opt,name=txid" json:"txid,omitempty"`
+	// key holds the primary key columns of the row, if the table has one.
+	Key *google_protobuf.Struct `protobuf:"bytes,9,opt,name=key" json:"key,omitempty"`
+	// sequence is the position of the change within its transaction, starting
+	// at 1. For COMMIT events it is the number of changes in the transaction.
+	Sequence int64 `protobuf:"varint,10,opt,name=sequence" json:"sequence,omitempty"`
+	// commit_time is the time the transaction committed, if known.
+	CommitTime *google_protobuf1.Timestamp `protobuf:"bytes,11,opt,name=commit_time,json=commitTime" json:"commit_time,omitempty"`
+	// change_time is the time the change was made according to the database,
+	// if known.
+	ChangeTime *google_protobuf1.Timestamp `protobuf:"bytes,12,opt,name=change_time,json=changeTime" json:"change_time,omitempty"`
+	// receive_time is the time the server received the change.
+	ReceiveTime *google_protobuf1.Timestamp `protobuf:"bytes,13,opt,name=receive_time,json=receiveTime" json:"receive_time,omitempty"`
+	// database is the name of the database the change was made in.
+	Database string `protobuf:"bytes,14,opt,name=database" json:"database,omitempty"`
+	// database_host is the address of the database server, or the server's
+	// host name if it connects over a unix socket.
+	DatabaseHost string `protobuf:"bytes,15,opt,name=database_host,json=databaseHost" json:"database_host,omitempty"`
+	// server is the host name of the server that sent the event.
+	Server string `protobuf:"bytes,16,opt,name=server" json:"server,omitempty"`
+	// change_format is the representation of the changes of UPDATE events
+	// requested by the client.
+	ChangeFormat ChangeFormat `protobuf:"varint,17,opt,name=change_format,json=changeFormat,enum=pqs.ChangeFormat" json:"change_format,omitempty"`
+	// patch is, in the event of op==UPDATE and change_format==JSON_PATCH, the
+	// RFC6902 JSON Patch that turns the previous row into payload. If the
+	// previous row is not known every column is added, replacing its value.
+	Patch []*PatchOperation `protobuf:"bytes,18,rep,name=patch" json:"patch,omitempty"`
+	// column_changes is, in the event of op==UPDATE and
+	// change_format==COLUMN_VALUES, the values of the changed columns keyed by
+	// column name.
+	ColumnChanges map[string]*ColumnChange `protobuf:"bytes,19,rep,name=column_changes,json=columnChanges" json:"column_changes,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// previous is the row before an UPDATE. It is used by the server to
+	// represent changes and is not sent to clients.
+	Previous *google_protobuf.Struct `protobuf:"bytes,20,opt,name=previous" json:"previous,omitempty"`
+	// unknown_columns are columns left out of payload and changes as their
+	// value is not known, i.e. unchanged TOASTed values of updates read by
+	// logical replication from tables without REPLICA IDENTITY FULL.
+	UnknownColumns []string `protobuf:"bytes,21,rep,name=unknown_columns,json=unknownColumns" json:"unknown_columns,omitempty"`
+	// epoch identifies the stream that assigned position: a run of the server,
+	// or the stream stored in the database when changes are read from an outbox
+	// or a replication slot. Positions of different epochs are unrelated.
+	Epoch string `protobuf:"bytes,22,opt,name=epoch" json:"epoch,omitempty"`
 }
 
 func (m *Event) Reset()                    { *m = Event{} }
 func (m *Event) String() string            { return proto.CompactTextString(m) }
 func (*Event) ProtoMessage()               {}
-func (*Event) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+func (*Event) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
 
 func (m *Event) GetSchema() string {
 	if m != nil {
@@ -201,11 +525,127 @@ func (m *Event) GetChanges() *google_protobuf.Struct {
 	return nil
 }
 
+func (m *Event) GetPosition() uint64 {
+	if m != nil {
+		return m.Position
+	}
+	return 0
+}
+
+func (m *Event) GetTxid() int64 {
+	if m != nil {
+		return m.Txid
+	}
+	return 0
+}
+
+func (m *Event) GetKey() *google_protobuf.Struct {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *Event) GetSequence() int64 {
+	if m != nil {
+		return m.Sequence
+	}
+	return 0
+}
+
+func (m *Event) GetCommitTime() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.CommitTime
+	}
+	return nil
+}
+
+func (m *Event) GetChangeTime() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.ChangeTime
+	}
+	return nil
+}
+
+func (m *Event) GetReceiveTime() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.ReceiveTime
+	}
+	return nil
+}
+
+func (m *Event) GetDatabase() string {
+	if m != nil {
+		return m.Database
+	}
+	return ""
+}
+
+func (m *Event) GetDatabaseHost() string {
+	if m != nil {
+		return m.DatabaseHost
+	}
+	return ""
+}
+
+func (m *Event) GetServer() string {
+	if m != nil {
+		return m.Server
+	}
+	return ""
+}
+
+func (m *Event) GetChangeFormat() ChangeFormat {
+	if m != nil {
+		return m.ChangeFormat
+	}
+	return ChangeFormat_MERGE_PATCH
+}
+
+func (m *Event) GetPatch() []*PatchOperation {
+	if m != nil {
+		return m.Patch
+	}
+	return nil
+}
+
+func (m *Event) GetColumnChanges() map[string]*ColumnChange {
+	if m != nil {
+		return m.ColumnChanges
+	}
+	return nil
+}
+
+func (m *Event) GetPrevious() *google_protobuf.Struct {
+	if m != nil {
+		return m.Previous
+	}
+	return nil
+}
+
+func (m *Event) GetUnknownColumns() []string {
+	if m != nil {
+		return m.UnknownColumns
+	}
+	return nil
+}
+
+func (m *Event) GetEpoch() string {
+	if m != nil {
+		return m.Epoch
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*ListenRequest)(nil), "pqs.ListenRequest")
+	proto.RegisterType((*ColumnSet)(nil), "pqs.ColumnSet")
+	proto.RegisterType((*PatchOperation)(nil), "pqs.PatchOperation")
+	proto.RegisterType((*ColumnChange)(nil), "pqs.ColumnChange")
 	proto.RegisterType((*RawEvent)(nil), "pqs.RawEvent")
 	proto.RegisterType((*Event)(nil), "pqs.Event")
 	proto.RegisterEnum("pqs.Operation", Operation_name, Operation_value)
+	proto.RegisterEnum("pqs.ChangeFormat", ChangeFormat_name, ChangeFormat_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -221,6 +661,10 @@ const _ = grpc.SupportPackageIsVersion4
 type PQStreamClient interface {
 	// Listen responds with a stream of database operations.
 	Listen(ctx context.Context, in *ListenRequest, opts ...grpc.CallOption) (PQStream_ListenClient, error)
+	// ListenWithSnapshot responds with the current contents of the matching
+	// tables as SNAPSHOT events, read in a single consistent transaction,
+	// followed by the stream of database operations made after it.
+	ListenWithSnapshot(ctx context.Context, in *ListenRequest, opts ...grpc.CallOption) (PQStream_ListenWithSnapshotClient, error)
 }
 
 type pQStreamClient struct {
@@ -263,11 +707,47 @@ func (x *pQStreamListenClient) Recv() (*Event, error) {
 	return m, nil
 }
 
+func (c *pQStreamClient) ListenWithSnapshot(ctx context.Context, in *ListenRequest, opts ...grpc.CallOption) (PQStream_ListenWithSnapshotClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_PQStream_serviceDesc.Streams[1], c.cc, "/pqs.PQStream/ListenWithSnapshot", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &pQStreamListenWithSnapshotClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type PQStream_ListenWithSnapshotClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type pQStreamListenWithSnapshotClient struct {
+	grpc.ClientStream
+}
+
+func (x *pQStreamListenWithSnapshotClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Server API for PQStream service
 
 type PQStreamServer interface {
 	// Listen responds with a stream of database operations.
 	Listen(*ListenRequest, PQStream_ListenServer) error
+	// ListenWithSnapshot responds with the current contents of the matching
+	// tables as SNAPSHOT events, read in a single consistent transaction,
+	// followed by the stream of database operations made after it.
+	ListenWithSnapshot(*ListenRequest, PQStream_ListenWithSnapshotServer) error
 }
 
 func RegisterPQStreamServer(s *grpc.Server, srv PQStreamServer) {
@@ -295,6 +775,27 @@ func (x *pQStreamListenServer) Send(m *Event) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _PQStream_ListenWithSnapshot_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListenRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PQStreamServer).ListenWithSnapshot(m, &pQStreamListenWithSnapshotServer{stream})
+}
+
+type PQStream_ListenWithSnapshotServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type pQStreamListenWithSnapshotServer struct {
+	grpc.ServerStream
+}
+
+func (x *pQStreamListenWithSnapshotServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _PQStream_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "pqs.PQStream",
 	HandlerType: (*PQStreamServer)(nil),
@@ -305,6 +806,11 @@ var _PQStream_serviceDesc = grpc.ServiceDesc{
 			Handler:       _PQStream_Listen_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "ListenWithSnapshot",
+			Handler:       _PQStream_ListenWithSnapshot_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "pqstream.proto",
 }
@@ -312,29 +818,71 @@ var _PQStream_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("pqstream.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 373 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xcc, 0x92, 0x4f, 0xcf, 0xd2, 0x40,
-	0x10, 0xc6, 0xdf, 0x2d, 0x50, 0xca, 0x80, 0x4d, 0xb3, 0x31, 0xda, 0x70, 0x30, 0xd8, 0x13, 0x31,
-	0xa6, 0x95, 0x12, 0x13, 0xaf, 0x46, 0x7a, 0x50, 0x49, 0xc1, 0xa5, 0xc4, 0xa3, 0xd9, 0x96, 0xb5,
-	0x34, 0x29, 0xdd, 0x6d, 0x77, 0x8b, 0xf2, 0x19, 0xbd, 0xf8, 0x91, 0x0c, 0xcb, 0x9f, 0xc4, 0x8b,
-	0x5e, 0xdf, 0xd3, 0xce, 0x3c, 0xf3, 0x3c, 0xc9, 0xfc, 0x36, 0x03, 0xb6, 0xa8, 0xa5, 0x6a, 0x18,
-	0x3d, 0xf8, 0xa2, 0xe1, 0x8a, 0xe3, 0x8e, 0xa8, 0xe5, 0xf8, 0x6d, 0x5e, 0xa8, 0x7d, 0x9b, 0xfa,
-	0x19, 0x3f, 0x04, 0x39, 0x2f, 0x69, 0x95, 0x07, 0x7a, 0x9a, 0xb6, 0xdf, 0x03, 0xa1, 0x4e, 0x82,
-	0xc9, 0x40, 0xaa, 0xa6, 0xcd, 0xd4, 0xf5, 0xb9, 0x64, 0xbd, 0x10, 0x9e, 0x2c, 0x0b, 0xa9, 0x58,
-	0x45, 0x58, 0xdd, 0x32, 0xa9, 0xf0, 0x4b, 0x18, 0x29, 0x9a, 0x96, 0xec, 0x5b, 0xc3, 0x72, 0xf6,
-	0x53, 0xb8, 0x68, 0x82, 0xa6, 0x03, 0x32, 0xd4, 0x1a, 0xd1, 0x92, 0xf7, 0x1b, 0x81, 0x45, 0xe8,
-	0x8f, 0xe8, 0xc8, 0x2a, 0x85, 0x9f, 0x81, 0x29, 0xb3, 0x3d, 0x3b, 0xd0, 0xab, 0xf3, 0xda, 0xe1,
-	0xa7, 0xd0, 0xd3, 0x19, 0xd7, 0xd0, 0xf2, 0xa5, 0xc1, 0x2f, 0xc0, 0xe0, 0xc2, 0xed, 0x4c, 0xd0,
-	0xd4, 0x0e, 0x6d, 0x5f, 0xd4, 0xd2, 0x5f, 0x09, 0xd6, 0x50, 0x55, 0xf0, 0x8a, 0x18, 0x5c, 0x60,
-	0x1b, 0x8c, 0x62, 0xe7, 0x76, 0x75, 0xc4, 0x28, 0x76, 0x78, 0x06, 0x7d, 0x41, 0x4f, 0x25, 0xa7,
-	0x3b, 0xb7, 0x37, 0x41, 0xd3, 0x61, 0xf8, 0xdc, 0xcf, 0x39, 0xcf, 0x4b, 0xe6, 0xdf, 0xe0, 0xfc,
-	0x8d, 0xc6, 0x21, 0x37, 0x1f, 0x9e, 0x83, 0x25, 0x1a, 0x76, 0x2c, 0x78, 0x2b, 0x5d, 0xf3, 0xdf,
-	0x99, 0xbb, 0xd1, 0xfb, 0x85, 0xa0, 0xf7, 0x48, 0x79, 0x66, 0xd0, 0xcf, 0xf6, 0xb4, 0xca, 0xd9,
-	0x7f, 0x71, 0x6e, 0xbe, 0x57, 0x9f, 0x60, 0x70, 0x5f, 0x03, 0x0f, 0xa1, 0xbf, 0x8d, 0x3f, 0xc7,
-	0xab, 0xaf, 0xb1, 0xf3, 0x80, 0x01, 0xcc, 0x8f, 0xf1, 0x26, 0x22, 0x89, 0x83, 0xce, 0xf5, 0x76,
-	0xbd, 0x78, 0x9f, 0x44, 0x8e, 0x71, 0xae, 0x17, 0xd1, 0x32, 0x4a, 0x22, 0xa7, 0x83, 0x47, 0x60,
-	0x25, 0x64, 0x1b, 0x7f, 0x38, 0x4f, 0xba, 0xe1, 0x3b, 0xb0, 0xd6, 0x5f, 0x36, 0xfa, 0xdc, 0xf0,
-	0x6b, 0x30, 0x2f, 0xc7, 0x82, 0xb1, 0x66, 0xfd, 0xeb, 0x72, 0xc6, 0xa0, 0x35, 0xfd, 0x8b, 0xde,
-	0xc3, 0x1b, 0x94, 0x9a, 0x7a, 0xbf, 0xf9, 0x9f, 0x00, 0x00, 0x00, 0xff, 0xff, 0x75, 0xcc, 0x14,
-	0x9e, 0xaf, 0x02, 0x00, 0x00,
+	// 1050 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xcc, 0x56, 0x5f, 0x73, 0xda, 0x46,
+	0x10, 0x8f, 0x90, 0xf9, 0xb7, 0x80, 0x22, 0x5f, 0x52, 0x57, 0xc3, 0x4c, 0x1b, 0x42, 0x3b, 0x13,
+	0x92, 0x49, 0x71, 0xe3, 0x4c, 0x3b, 0x69, 0x33, 0x7d, 0x70, 0xb0, 0x12, 0x3b, 0xb5, 0x81, 0x1e,
+	0x38, 0x79, 0x64, 0x84, 0x38, 0x83, 0xc6, 0x48, 0x27, 0xeb, 0x0e, 0x3b, 0xfe, 0x82, 0xfd, 0x24,
+	0x9d, 0xe9, 0xd7, 0xe8, 0xdc, 0x9e, 0x84, 0x71, 0xf1, 0xd8, 0x7e, 0xcc, 0x13, 0xb7, 0x7f, 0x7e,
+	0xdc, 0xed, 0xee, 0x6f, 0x77, 0x05, 0x56, 0x7c, 0x26, 0x64, 0xc2, 0xbc, 0xb0, 0x1d, 0x27, 0x5c,
+	0x72, 0x62, 0xc6, 0x67, 0xa2, 0xfe, 0xcb, 0x34, 0x90, 0xb3, 0xc5, 0xb8, 0xed, 0xf3, 0x70, 0x7b,
+	0xca, 0xe7, 0x5e, 0x34, 0xdd, 0x46, 0xeb, 0x78, 0x71, 0xb2, 0x1d, 0xcb, 0xcb, 0x98, 0x89, 0x6d,
+	0x21, 0x93, 0x85, 0x2f, 0xd3, 0x1f, 0x8d, 0xad, 0xbf, 0xbd, 0x1b, 0x26, 0x83, 0x90, 0x09, 0xe9,
+	0x85, 0xf1, 0xd5, 0x49, 0x83, 0x9b, 0xff, 0x9a, 0x50, 0x3b, 0x0c, 0x84, 0x64, 0x11, 0x65, 0x67,
+	0x0b, 0x26, 0x24, 0x79, 0x0a, 0x55, 0xe9, 0x8d, 0xe7, 0x6c, 0x94, 0xb0, 0x29, 0xfb, 0x12, 0x3b,
+	0x46, 0xc3, 0x68, 0x95, 0x69, 0x05, 0x75, 0x14, 0x55, 0xe4, 0x09, 0x54, 0x12, 0x26, 0x16, 0x21,
+	0x1b, 0x9d, 0x24, 0x3c, 0x74, 0x72, 0x0d, 0xa3, 0xb5, 0x41, 0x41, 0xab, 0xde, 0x27, 0x3c, 0x24,
+	0x0d, 0x30, 0x79, 0x2c, 0x1c, 0xb3, 0x61, 0xb6, 0xac, 0x1d, 0xab, 0x1d, 0x9f, 0x89, 0x76, 0x2f,
+	0x66, 0x89, 0x27, 0x03, 0x1e, 0x51, 0x65, 0x22, 0x3f, 0x40, 0x4d, 0xf8, 0x33, 0x16, 0x7a, 0xd9,
+	0x35, 0x1b, 0x78, 0x4d, 0x55, 0x2b, 0xd3, 0x7b, 0xb6, 0xa0, 0x70, 0x12, 0xcc, 0x25, 0x4b, 0x9c,
+	0x3c, 0x5a, 0x53, 0x89, 0xfc, 0x06, 0x45, 0x9f, 0xcf, 0x17, 0x61, 0x24, 0x9c, 0x42, 0xc3, 0x6c,
+	0x55, 0x76, 0x9e, 0xe0, 0x15, 0xd7, 0xe2, 0x68, 0x77, 0xb4, 0x87, 0x1b, 0xc9, 0xe4, 0x92, 0x66,
+	0xfe, 0xa4, 0x09, 0x55, 0x99, 0x78, 0x91, 0xf0, 0x7c, 0xf5, 0x16, 0xe1, 0x14, 0x1b, 0x46, 0xab,
+	0x44, 0xaf, 0xe9, 0xc8, 0x4f, 0x40, 0x12, 0x36, 0xd1, 0xd2, 0x28, 0x4e, 0xf8, 0x49, 0x30, 0x67,
+	0xc2, 0x29, 0x35, 0xcc, 0x56, 0x99, 0x6e, 0x2e, 0x2d, 0xfd, 0xd4, 0x40, 0x7e, 0x85, 0x9a, 0x3f,
+	0xf3, 0xa2, 0x29, 0x1b, 0x9d, 0xf0, 0x24, 0xf4, 0xa4, 0x53, 0x6e, 0x18, 0x2d, 0x6b, 0x67, 0x13,
+	0xdf, 0xd4, 0x41, 0xcb, 0x7b, 0x34, 0xd0, 0xaa, 0xbf, 0x22, 0xa9, 0x44, 0xa7, 0x59, 0x64, 0x31,
+	0xf7, 0x67, 0x0e, 0xe8, 0x44, 0x6b, 0x9d, 0xab, 0x54, 0xf5, 0x8f, 0x50, 0x5d, 0x0d, 0x83, 0xd8,
+	0x60, 0x9e, 0xb2, 0xcb, 0xb4, 0x24, 0xea, 0x48, 0x7e, 0x84, 0xfc, 0xb9, 0x37, 0x5f, 0x30, 0x2c,
+	0x42, 0x25, 0xcd, 0xb5, 0xc6, 0x0c, 0x98, 0xa4, 0xda, 0xf8, 0x7b, 0xee, 0x8d, 0xd1, 0x7c, 0x0a,
+	0xe5, 0xa5, 0x9e, 0x3c, 0x86, 0x7c, 0xe4, 0x85, 0x4c, 0x38, 0x06, 0x46, 0xa5, 0x85, 0xe6, 0x18,
+	0xac, 0xbe, 0x27, 0xfd, 0xd9, 0xb2, 0x56, 0xc4, 0x82, 0x1c, 0xcf, 0x28, 0x90, 0xe3, 0x31, 0x21,
+	0xb0, 0x11, 0x7b, 0x72, 0x86, 0xb7, 0x95, 0x29, 0x9e, 0xc9, 0xcb, 0xec, 0x09, 0x26, 0x3e, 0x61,
+	0xab, 0x3d, 0xe5, 0x7c, 0x3a, 0x67, 0xed, 0x8c, 0x84, 0xed, 0x4f, 0xca, 0x9a, 0x3e, 0xa5, 0x39,
+	0xce, 0x42, 0xd2, 0x99, 0x21, 0x2d, 0x30, 0xf9, 0x7c, 0xe2, 0x18, 0xb7, 0x62, 0x95, 0x8b, 0xf2,
+	0x8c, 0xd8, 0x85, 0x93, 0xbb, 0xdd, 0x33, 0x62, 0x17, 0xcd, 0x7f, 0x4c, 0x28, 0x51, 0xef, 0xc2,
+	0x3d, 0x67, 0x91, 0x54, 0x24, 0xd2, 0xa4, 0x4a, 0xc3, 0x48, 0x25, 0x95, 0x02, 0xe4, 0x74, 0x1a,
+	0x8b, 0x16, 0xc8, 0xf7, 0x18, 0xb0, 0x89, 0x15, 0xfc, 0x3f, 0x71, 0x55, 0x02, 0x2c, 0xc8, 0x05,
+	0x93, 0x94, 0xac, 0xb9, 0x60, 0x42, 0x5e, 0x41, 0x31, 0xf6, 0x2e, 0xe7, 0xdc, 0x9b, 0x20, 0x47,
+	0x2b, 0x3b, 0xdf, 0xae, 0x3d, 0x6c, 0x80, 0xcd, 0x4a, 0x33, 0x3f, 0xf2, 0x1a, 0x4a, 0x71, 0xc2,
+	0xce, 0x03, 0xbe, 0x50, 0xf4, 0xbd, 0x15, 0xb3, 0x74, 0x54, 0x89, 0x97, 0x5f, 0x82, 0x09, 0xf2,
+	0xd5, 0xa4, 0x78, 0x26, 0xcf, 0x35, 0x1b, 0x4a, 0xb7, 0xff, 0x07, 0xd2, 0x44, 0x05, 0xcb, 0x4f,
+	0x59, 0x84, 0xdc, 0x34, 0xa9, 0x16, 0x48, 0x1d, 0x4a, 0x42, 0x75, 0x4b, 0xe4, 0x33, 0x64, 0x9f,
+	0x49, 0x97, 0x32, 0x79, 0x0b, 0x15, 0x9f, 0x87, 0x61, 0x20, 0x47, 0x6a, 0x64, 0x38, 0x15, 0xbc,
+	0xa4, 0xbe, 0x76, 0xc9, 0x30, 0x9b, 0x27, 0x14, 0xb4, 0xbb, 0x52, 0x20, 0x58, 0xb7, 0x04, 0x82,
+	0xab, 0xf7, 0x00, 0xa3, 0x3b, 0x82, 0x9f, 0xc1, 0xc3, 0x45, 0x74, 0x1a, 0xf1, 0x8b, 0x68, 0x94,
+	0x75, 0x79, 0x0d, 0x59, 0x6a, 0xa5, 0xea, 0xb4, 0x25, 0x9a, 0x7f, 0x17, 0x21, 0xff, 0x95, 0xd6,
+	0xf8, 0x15, 0x14, 0x75, 0x44, 0x77, 0x96, 0x38, 0xf3, 0x53, 0xc5, 0x88, 0xb9, 0x08, 0xd4, 0x2b,
+	0xb0, 0xca, 0x1b, 0x74, 0x29, 0x2f, 0xab, 0x5f, 0x5a, 0xaf, 0x7e, 0xf9, 0x1e, 0xd5, 0xff, 0x3a,
+	0xeb, 0xfc, 0x87, 0x9a, 0x7f, 0x3e, 0x0b, 0xce, 0x53, 0x74, 0xed, 0x4e, 0x74, 0x25, 0xf5, 0x47,
+	0x78, 0x1d, 0x4a, 0x13, 0x4f, 0x7a, 0x63, 0x4f, 0x30, 0xc7, 0xc2, 0x5a, 0x2d, 0x65, 0xb5, 0x5d,
+	0xb2, 0xf3, 0x68, 0xc6, 0x85, 0x74, 0x1e, 0xea, 0xed, 0x92, 0x29, 0xf7, 0xb9, 0xd0, 0xa4, 0x61,
+	0xc9, 0x39, 0x4b, 0x1c, 0x3b, 0x25, 0x0d, 0x4a, 0xeb, 0xf3, 0x7c, 0xf3, 0x7e, 0xf3, 0xfc, 0x39,
+	0xe4, 0x63, 0x35, 0x3d, 0x1d, 0x82, 0x3b, 0xe9, 0x11, 0xfa, 0x5f, 0x9f, 0xa7, 0x54, 0x7b, 0x90,
+	0x3d, 0xb0, 0x34, 0xb5, 0x47, 0x19, 0x4b, 0x1e, 0x21, 0xe6, 0x3b, 0xc4, 0x20, 0xa7, 0xdb, 0xab,
+	0x53, 0x32, 0xdd, 0x62, 0x35, 0x7f, 0x55, 0x77, 0x6d, 0x90, 0x3c, 0xbe, 0xef, 0x20, 0xb9, 0xa1,
+	0xbb, 0xbe, 0xb9, 0xa9, 0xbb, 0x54, 0xef, 0xe8, 0xbd, 0xb4, 0xa5, 0x7b, 0x07, 0x85, 0xfa, 0x00,
+	0xc8, 0xfa, 0xc3, 0x6e, 0xd8, 0x4b, 0xcf, 0xae, 0xef, 0xa5, 0xcd, 0x95, 0xbd, 0xa4, 0x91, 0x2b,
+	0xab, 0xe9, 0x05, 0x87, 0xf2, 0xd5, 0xca, 0xa9, 0x40, 0xf1, 0xb8, 0xfb, 0x67, 0xb7, 0xf7, 0xb9,
+	0x6b, 0x3f, 0x20, 0x00, 0x85, 0x83, 0xee, 0xc0, 0xa5, 0x43, 0xdb, 0x50, 0xe7, 0xe3, 0xfe, 0xde,
+	0xee, 0xd0, 0xb5, 0x73, 0xea, 0xbc, 0xe7, 0x1e, 0xba, 0x43, 0xd7, 0x36, 0x49, 0x15, 0x4a, 0x43,
+	0x7a, 0xdc, 0xed, 0x28, 0xcb, 0x86, 0x92, 0x06, 0xdd, 0xdd, 0xfe, 0x60, 0xbf, 0x37, 0xb4, 0xf3,
+	0xa4, 0x0c, 0xf9, 0x77, 0xee, 0x87, 0x83, 0xae, 0x5d, 0x50, 0x90, 0x4e, 0xef, 0xe8, 0xe8, 0x60,
+	0x68, 0x17, 0x5f, 0xbc, 0x83, 0xea, 0x6a, 0x21, 0xc9, 0x43, 0xa8, 0x1c, 0xb9, 0xf4, 0x83, 0x3b,
+	0xea, 0xef, 0x0e, 0x3b, 0xfb, 0xf6, 0x03, 0x62, 0x01, 0x7c, 0x1c, 0xf4, 0xba, 0xa9, 0x6c, 0x90,
+	0x4d, 0xa8, 0x75, 0x7a, 0x87, 0xc7, 0x47, 0xdd, 0xd1, 0xa7, 0xdd, 0xc3, 0x63, 0x77, 0x60, 0xe7,
+	0x76, 0x12, 0x28, 0xf5, 0xff, 0x1a, 0xe0, 0x47, 0x1c, 0x79, 0x09, 0x05, 0xfd, 0xf1, 0x41, 0xc8,
+	0xfa, 0x97, 0x48, 0x1d, 0xae, 0xaa, 0xda, 0x7c, 0xf0, 0xb3, 0x41, 0xde, 0x00, 0xd1, 0x0e, 0x9f,
+	0x03, 0x39, 0x1b, 0x44, 0x5e, 0x2c, 0x66, 0x5c, 0xde, 0x07, 0x39, 0x2e, 0x60, 0x5d, 0x5f, 0xff,
+	0x37, 0x00, 0x7f, 0xa3, 0x12, 0x43, 0x3f, 0x0a, 0x00, 0x00,
 }
@@ -0,0 +1,51 @@
+package pqstream
+
+import (
+	"github.com/tmc/pqstream/pqs"
+
+	ptypes_struct "github.com/golang/protobuf/ptypes/struct"
+)
+
+// eventProjection returns a function that trims the payload, changes and previous row of events to the columns
+// requested for their table.
+func eventProjection(r *pqs.ListenRequest) func(*pqs.Event) *pqs.Event {
+	if len(r.Columns) == 0 {
+		return func(e *pqs.Event) *pqs.Event { return e }
+	}
+	keep := make(map[string]map[string]bool, len(r.Columns))
+	for table, columns := range r.Columns {
+		// the id is always kept so events can still be correlated with rows.
+		cols := map[string]bool{"id": true}
+		for _, c := range columns.GetNames() {
+			cols[c] = true
+		}
+		keep[table] = cols
+	}
+	return func(e *pqs.Event) *pqs.Event {
+		cols, ok := keep[e.Schema+"."+e.Table]
+		if !ok {
+			cols, ok = keep[e.Table]
+		}
+		if !ok {
+			return e
+		}
+		p := *e
+		p.Payload = projectStruct(e.Payload, cols)
+		p.Changes = projectStruct(e.Changes, cols)
+		p.Previous = projectStruct(e.Previous, cols)
+		return &p
+	}
+}
+
+func projectStruct(s *ptypes_struct.Struct, cols map[string]bool) *ptypes_struct.Struct {
+	if s == nil {
+		return nil
+	}
+	p := &ptypes_struct.Struct{Fields: make(map[string]*ptypes_struct.Value, len(cols))}
+	for k, v := range s.Fields {
+		if cols[k] {
+			p.Fields[k] = v
+		}
+	}
+	return p
+}
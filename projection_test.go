@@ -0,0 +1,44 @@
+package pqstream
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/tmc/pqstream/pqs"
+)
+
+func Test_eventProjection(t *testing.T) {
+	const event = `{"schema":"public","table":"users","op":"UPDATE","id":"1","payload":{"email":"a@b.c","id":1,"name":"a"},"changes":{"email":"old@b.c","name":"b"}}`
+	tests := []struct {
+		name    string
+		columns map[string]*pqs.ColumnSet
+		want    string
+	}{
+		{"none", nil, event},
+		{"other_table", map[string]*pqs.ColumnSet{"notes": {Names: []string{"note"}}}, event},
+		{"table", map[string]*pqs.ColumnSet{"users": {Names: []string{"email"}}},
+			`{"schema":"public","table":"users","op":"UPDATE","id":"1","payload":{"email":"a@b.c","id":1},"changes":{"email":"old@b.c"}}`},
+		{"qualified", map[string]*pqs.ColumnSet{
+			"public.users": {Names: []string{"name"}},
+			"users":        {Names: []string{"email"}},
+		}, `{"schema":"public","table":"users","op":"UPDATE","id":"1","payload":{"id":1,"name":"a"},"changes":{"name":"b"}}`},
+		{"only_id", map[string]*pqs.ColumnSet{"users": {}},
+			`{"schema":"public","table":"users","op":"UPDATE","id":"1","payload":{"id":1},"changes":{}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &pqs.Event{}
+			if err := jsonpb.UnmarshalString(event, e); err != nil {
+				t.Fatal(err)
+			}
+			project := eventProjection(&pqs.ListenRequest{Columns: tt.columns})
+			got, err := (&jsonpb.Marshaler{}).MarshalToString(applyShared(t, project, e))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("eventProjection() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
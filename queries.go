@@ -1,41 +1,110 @@
 package pqstream
 
+import "text/template"
+
 var (
 	sqlQueryTables = `
-SELECT table_name
+SELECT table_schema, table_name
   FROM information_schema.tables
- WHERE table_schema='public'
+ WHERE table_schema NOT IN ('pg_catalog', 'information_schema')
    AND table_type='BASE TABLE'
+   AND table_name NOT LIKE 'pqstream\_%'
+ ORDER BY table_schema, table_name
+`
+	sqlQueryColumns = `
+SELECT column_name
+  FROM information_schema.columns
+ WHERE table_schema = $1
+   AND table_name = $2
+ ORDER BY ordinal_position
 `
-	sqlTriggerFunction = `
-CREATE OR REPLACE FUNCTION pqstream_notify() RETURNS TRIGGER AS $$
+	// sqlTriggerFunction is executed with a triggerFunctionOptions.
+	sqlTriggerFunction = template.Must(template.New("pqstream_notify").Parse(`
+CREATE OR REPLACE FUNCTION {{.Function}}() RETURNS TRIGGER AS $$
     DECLARE 
         payload json;
         previous json;
+        pkey json;
+        keyrow json;
         notification json;
+        token bigint;
+        seq bigint;
+        changed text;
     BEGIN
+        changed = to_char(clock_timestamp() AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"');
+        -- number the changes of each transaction, transaction local settings are reset when it ends.
+        seq = coalesce(nullif(current_setting('pqstream.sequence', true), ''), '0')::bigint + 1;
+        PERFORM set_config('pqstream.sequence', seq::text, true);
+{{- if .Commit}}
+        -- the commit trigger only fires for changed rows, truncations fire it through pqstream_commits.
+        IF (TG_OP = 'TRUNCATE') THEN
+            INSERT INTO pqstream_commits (txid) VALUES (txid_current());
+        END IF;
+{{- end}}
         IF (TG_OP = 'DELETE') THEN
-            payload = row_to_json(OLD);
-        ELSE
-            payload = row_to_json(NEW);
+            payload = {{.Row "OLD"}};
+        ELSIF (TG_OP <> 'TRUNCATE') THEN
+            payload = {{.Row "NEW"}};
         END IF;
         IF (TG_OP = 'UPDATE') THEN
-            previous = row_to_json(OLD);
+            previous = {{.Row "OLD"}};
+        END IF;
+        -- the trigger arguments are the primary key columns of the table.
+        IF (TG_NARGS > 0 AND payload IS NOT NULL) THEN
+{{- if .Exclude}}
+            -- excluded columns may be part of the key, so it is read from the whole row.
+            IF (TG_OP = 'DELETE') THEN
+                keyrow = row_to_json(OLD);
+            ELSE
+                keyrow = row_to_json(NEW);
+            END IF;
+{{- else}}
+            keyrow = payload;
+{{- end}}
+            SELECT json_object_agg(k, json_extract_path(keyrow, k)) INTO pkey FROM unnest(TG_ARGV) k;
         END IF;
         
         notification = json_build_object(
                           'schema', TG_TABLE_SCHEMA,
                           'table', TG_TABLE_NAME,
                           'op', TG_OP,
+                          'txid', txid_current(),
+                          'sequence', seq,
+                          'change_time', changed,
 						  'id', json_extract_path(payload, 'id')::text,
+                          'key', pkey,
                           'payload', payload,
 						  'previous', previous);
+{{- if .Outbox}}
+        INSERT INTO pqstream_outbox (notification) VALUES (notification);
+        PERFORM pg_notify('pqstream_notify', '');
+{{- else if .PayloadTable}}
+        IF (length(notification::text) >= 8000) THEN
+          INSERT INTO pqstream_payloads (notification) VALUES (notification) RETURNING id INTO token;
+          notification = json_build_object(
+                          'schema', TG_TABLE_SCHEMA,
+                          'table', TG_TABLE_NAME,
+                          'op', TG_OP,
+                          'txid', txid_current(),
+                          'sequence', seq,
+                          'change_time', changed,
+                          'id', json_extract_path(payload, 'id')::text,
+                          'key', pkey,
+                          'token', token);
+        END IF;
+
+        PERFORM pg_notify('pqstream_notify', notification::text);
+{{- else}}
         IF (length(notification::text) >= 8000) THEN
           notification = json_build_object(
                           'schema', TG_TABLE_SCHEMA,
                           'table', TG_TABLE_NAME,
                           'op', TG_OP,
+                          'txid', txid_current(),
+                          'sequence', seq,
+                          'change_time', changed,
 						  'id', json_extract_path(payload, 'id')::text,
+                          'key', pkey,
 						  'payload', payload);
         END IF;
         IF (length(notification::text) >= 8000) THEN
@@ -43,23 +112,241 @@ CREATE OR REPLACE FUNCTION pqstream_notify() RETURNS TRIGGER AS $$
                             'schema', TG_TABLE_SCHEMA,
                             'table', TG_TABLE_NAME,
                             'op', TG_OP,
-							'id', json_extract_path(payload, 'id')::text);
+                            'txid', txid_current(),
+                            'sequence', seq,
+                            'change_time', changed,
+							'id', json_extract_path(payload, 'id')::text,
+                            'key', pkey);
         END IF;
         
         PERFORM pg_notify('pqstream_notify', notification::text);
+{{- end}}
         RETURN NULL; 
     END;
 $$ LANGUAGE plpgsql;
-`
+`))
+	// sqlCommitFunction is executed with a triggerFunctionOptions. It is run by a deferred trigger when a
+	// transaction commits and sends a COMMIT event with the commit time after the transaction's changes.
+	sqlCommitFunction = template.Must(template.New("pqstream_notify_commit").Parse(`
+CREATE OR REPLACE FUNCTION pqstream_notify_commit() RETURNS TRIGGER AS $$
+    DECLARE
+        seq text;
+        notification json;
+    BEGIN
+        IF (TG_TABLE_NAME = 'pqstream_commits') THEN
+            DELETE FROM pqstream_commits WHERE txid = txid_current();
+        END IF;
+        -- the trigger fires for every changed row, only mark each transaction once.
+        seq = current_setting('pqstream.sequence', true);
+        IF (current_setting('pqstream.committed', true) = seq) THEN
+            RETURN NULL;
+        END IF;
+        PERFORM set_config('pqstream.committed', seq, true);
+        notification = json_build_object(
+                          'op', 'COMMIT',
+                          'txid', txid_current(),
+                          'sequence', nullif(seq, '')::bigint,
+                          'commit_time', to_char(clock_timestamp() AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'));
+{{- if .Outbox}}
+        INSERT INTO pqstream_outbox (notification) VALUES (notification);
+        PERFORM pg_notify('pqstream_notify', '');
+{{- else}}
+        PERFORM pg_notify('pqstream_notify', notification::text);
+{{- end}}
+        RETURN NULL;
+    END;
+$$ LANGUAGE plpgsql;
+`))
 	sqlRemoveTrigger = `
 DROP TRIGGER IF EXISTS pqstream_notify ON %s
 `
 	sqlInstallTrigger = `
 CREATE TRIGGER pqstream_notify
 AFTER INSERT OR UPDATE OR DELETE ON %s
-    FOR EACH ROW EXECUTE PROCEDURE pqstream_notify();
+    FOR EACH ROW EXECUTE PROCEDURE %s(%s);
+`
+	sqlRemoveTableFunction = `
+DROP FUNCTION IF EXISTS %s()
+`
+	sqlRemoveCommitTrigger = `
+DROP TRIGGER IF EXISTS pqstream_notify_commit ON %s
+`
+	sqlInstallCommitTrigger = `
+CREATE CONSTRAINT TRIGGER pqstream_notify_commit
+AFTER INSERT OR UPDATE OR DELETE ON %s
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE PROCEDURE pqstream_notify_commit();
+`
+	// sqlCreateCommits holds a row for each transaction that truncated a table until it commits.
+	sqlCreateCommits = `
+CREATE UNLOGGED TABLE IF NOT EXISTS pqstream_commits (
+    txid bigint NOT NULL
+)
+`
+	sqlDropCommits = `
+DROP TABLE IF EXISTS pqstream_commits
+`
+	sqlRemoveCommitsTrigger = `
+DROP TRIGGER IF EXISTS pqstream_notify_commit ON pqstream_commits
+`
+	sqlInstallCommitsTrigger = `
+CREATE CONSTRAINT TRIGGER pqstream_notify_commit
+AFTER INSERT ON pqstream_commits
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE PROCEDURE pqstream_notify_commit();
+`
+	// truncation is only reported by statement level triggers.
+	sqlRemoveTruncateTrigger = `
+DROP TRIGGER IF EXISTS pqstream_notify_truncate ON %s
+`
+	sqlInstallTruncateTrigger = `
+CREATE TRIGGER pqstream_notify_truncate
+AFTER TRUNCATE ON %s
+    FOR EACH STATEMENT EXECUTE PROCEDURE pqstream_notify();
+`
+	// sqlDDLFunction reports tables created by ddl commands on the control channel.
+	sqlDDLFunction = `
+CREATE OR REPLACE FUNCTION pqstream_notify_ddl() RETURNS event_trigger AS $$
+    DECLARE
+        r record;
+    BEGIN
+        FOR r IN SELECT * FROM pg_event_trigger_ddl_commands() WHERE object_type = 'table' LOOP
+            PERFORM pg_notify('pqstream_notify-ctl', json_build_object(
+                              'op', r.command_tag,
+                              'schema', r.schema_name,
+                              'object', r.object_identity)::text);
+        END LOOP;
+    END;
+$$ LANGUAGE plpgsql;
+`
+	sqlRemoveDDLTrigger = `
+DROP EVENT TRIGGER IF EXISTS pqstream_notify_ddl
+`
+	sqlRemoveDDLFunction = `
+DROP FUNCTION IF EXISTS pqstream_notify_ddl()
+`
+	sqlInstallDDLTrigger = `
+CREATE EVENT TRIGGER pqstream_notify_ddl ON ddl_command_end
+    WHEN TAG IN ('CREATE TABLE', 'CREATE TABLE AS', 'SELECT INTO')
+    EXECUTE PROCEDURE pqstream_notify_ddl();
+`
+	sqlCreateOutbox = `
+CREATE TABLE IF NOT EXISTS pqstream_outbox (
+    id bigserial PRIMARY KEY,
+    notification json NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+)
+`
+	sqlDropOutbox = `
+DROP TABLE IF EXISTS pqstream_outbox
+`
+	// sqlReadOutbox reads the entries of up to $1 transactions. Entries are numbered as they are written, so
+	// those of concurrent transactions interleave; the entries of each transaction are read together, in the
+	// order their last entry, normally the COMMIT, was written.
+	sqlReadOutbox = `
+WITH txs AS (
+    SELECT (notification->>'txid')::bigint AS txid, max(id) AS last_id
+      FROM pqstream_outbox
+     GROUP BY 1
+     ORDER BY last_id
+     LIMIT $1
+)
+SELECT o.id, o.notification::text
+  FROM pqstream_outbox o
+  JOIN txs ON (o.notification->>'txid')::bigint = txs.txid
+ ORDER BY txs.last_id, o.id
+`
+	sqlDeleteOutbox = `
+DELETE FROM pqstream_outbox WHERE id = ANY($1)
+`
+	// sqlCreatePosition creates the table storing the epoch and the position of the last event sent when changes
+	// are read from the outbox or a replication slot. It holds a single row.
+	sqlCreatePosition = `
+CREATE TABLE IF NOT EXISTS pqstream_position (
+    epoch text NOT NULL,
+    position bigint NOT NULL
+)
+`
+	sqlInitPosition = `
+INSERT INTO pqstream_position (epoch, position)
+SELECT $1, 0 WHERE NOT EXISTS (SELECT 1 FROM pqstream_position)
+`
+	sqlQueryPosition = `
+SELECT epoch, position FROM pqstream_position
+`
+	sqlSavePosition = `
+UPDATE pqstream_position SET position = $1
+`
+	sqlDropPosition = `
+DROP TABLE IF EXISTS pqstream_position
+`
+	sqlCreatePayloadTable = `
+CREATE TABLE IF NOT EXISTS pqstream_payloads (
+    id bigserial PRIMARY KEY,
+    notification json NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+)
+`
+	sqlDropPayloadTable = `
+DROP TABLE IF EXISTS pqstream_payloads
+`
+	sqlTakePayload = `
+DELETE FROM pqstream_payloads WHERE id = $1 RETURNING notification::text
+`
+	sqlExpirePayloads = `
+DELETE FROM pqstream_payloads WHERE created_at < now() - $1 * interval '1 second'
+`
+	sqlQueryIdentity = `
+SELECT current_database(), coalesce(host(inet_server_addr()), '')
+`
+	sqlQuerySnapshot = `
+SELECT txid_current_snapshot()::text
+`
+	sqlSnapshotTable = `
+SELECT row_to_json(r)::text FROM %s r
+`
+	// sqlFetchRowByKey is formatted with the table and its key columns, and takes the key as a json object
+	// which is converted to the column types by json_populate_record.
+	sqlFetchRowByKey = `
+SELECT row_to_json(r)::text FROM (
+    SELECT * FROM %[1]s WHERE (%[2]s) = (SELECT %[2]s FROM json_populate_record(NULL::%[1]s, $1::json))
+) r
+`
+	sqlQueryPrimaryKey = `
+SELECT a.attname
+  FROM pg_index i
+  JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+ WHERE i.indrelid = $1::regclass
+   AND i.indisprimary
+ ORDER BY array_position(i.indkey::int2[], a.attnum)
+`
+	sqlQueryPublication = `
+SELECT count(*) FROM pg_publication WHERE pubname = $1
+`
+	sqlCreatePublication = `
+CREATE PUBLICATION %s FOR TABLE %s
+`
+	sqlAlterPublication = `
+ALTER PUBLICATION %s SET TABLE %s
+`
+	sqlDropPublication = `
+DROP PUBLICATION IF EXISTS %s
+`
+	sqlQueryReplicationSlot = `
+SELECT count(*) FROM pg_replication_slots WHERE slot_name = $1
+`
+	sqlCreateReplicationSlot = `
+SELECT pg_create_logical_replication_slot($1, 'pgoutput')
+`
+	sqlDropReplicationSlot = `
+SELECT pg_drop_replication_slot(slot_name) FROM pg_replication_slots WHERE slot_name = $1
+`
+	sqlPeekReplicationSlot = `
+SELECT lsn::text, data
+  FROM pg_logical_slot_peek_binary_changes($1, NULL, $2, 'proto_version', '1', 'publication_names', $3)
 `
-	sqlFetchRowByID = `
-	SELECT row_to_json(r)::text from (select * from %s where id = $1::%s) r;
+	sqlAdvanceReplicationSlot = `
+SELECT count(*)
+  FROM pg_logical_slot_get_binary_changes($1, $2::pg_lsn, NULL, 'proto_version', '1', 'publication_names', $3)
 `
 )
@@ -0,0 +1,166 @@
+package pqstream
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/tmc/pqstream/pqs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultSubscriberQueueSize = 1024
+
+// OverflowPolicy controls what happens when events arrive for a subscriber whose queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for the subscriber to catch up, delaying events for all subscribers.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest queued event to make room.
+	OverflowDropOldest
+	// OverflowDisconnect ends the subscription with a ResourceExhausted error.
+	OverflowDisconnect
+)
+
+var overflowPolicyNames = map[OverflowPolicy]string{
+	OverflowBlock:      "block",
+	OverflowDropOldest: "drop-oldest",
+	OverflowDisconnect: "disconnect",
+}
+
+func (p OverflowPolicy) String() string {
+	if name, ok := overflowPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// ParseOverflowPolicy returns the policy with the given name ("block", "drop-oldest" or "disconnect").
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	for p, n := range overflowPolicyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overflow policy %q", name)
+}
+
+// WithSubscriberQueue sets the number of events queued for each subscriber and the policy applied once a
+// subscriber's queue is full. A size of zero does not limit the queues, so the policy never applies, and a
+// negative size makes NewServer fail.
+func WithSubscriberQueue(size int, policy OverflowPolicy) ServerOption {
+	return func(s *Server) {
+		s.queueSize = size
+		s.overflowPolicy = policy
+	}
+}
+
+// eventQueue is a queue of events for a single subscriber which signals ready after each push.
+//
+// Once it holds limit events further pushes are handled according to its policy; a zero limit means
+// the queue is unbounded.
+type eventQueue struct {
+	mu         sync.Mutex
+	events     []*pqs.Event
+	limit      int
+	policy     OverflowPolicy
+	dropped    int
+	overflowed bool
+
+	ready chan struct{}
+	space chan struct{}
+}
+
+func newEventQueue(limit int, policy OverflowPolicy) *eventQueue {
+	return &eventQueue{
+		limit:  limit,
+		policy: policy,
+		ready:  make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+	}
+}
+
+// setLimit changes the limit of the queue, events already queued are kept.
+func (q *eventQueue) setLimit(limit int) {
+	q.mu.Lock()
+	q.limit = limit
+	q.mu.Unlock()
+}
+
+// push adds e to the queue. It returns false once the subscriber should be removed, which is when done is
+// closed while blocked or the queue has overflowed with the disconnect policy.
+func (q *eventQueue) push(done <-chan struct{}, e *pqs.Event) bool {
+	q.mu.Lock()
+	for q.limit > 0 && len(q.events) >= q.limit && q.policy == OverflowBlock && !q.overflowed {
+		q.mu.Unlock()
+		select {
+		case <-done:
+			return false
+		case <-q.space:
+		}
+		q.mu.Lock()
+	}
+	ok := q.add(e)
+	q.mu.Unlock()
+	signal(q.ready)
+	return ok
+}
+
+func (q *eventQueue) add(e *pqs.Event) bool {
+	if q.overflowed {
+		q.dropped++
+		eventsDropped.Inc()
+		return false
+	}
+	if q.limit == 0 || len(q.events) < q.limit {
+		q.events = append(q.events, e)
+		return true
+	}
+	if q.policy == OverflowDropOldest {
+		q.dropped++
+		eventsDropped.Inc()
+		q.events = append(q.events[1:], e)
+		return true
+	}
+	// the queued events are discarded, the subscriber can resume after the last event it received.
+	q.dropped += len(q.events) + 1
+	eventsDropped.Add(float64(len(q.events) + 1))
+	q.events = nil
+	q.overflowed = true
+	return false
+}
+
+// pop removes and returns all queued events.
+func (q *eventQueue) pop() []*pqs.Event {
+	q.mu.Lock()
+	events := q.events
+	q.events = nil
+	q.mu.Unlock()
+	signal(q.space)
+	return events
+}
+
+// status returns the number of events dropped so far and whether the queue has overflowed.
+func (q *eventQueue) status() (dropped int, overflowed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped, q.overflowed
+}
+
+// overflowError returns a ResourceExhausted error if q has overflowed.
+func overflowError(q *eventQueue) error {
+	dropped, overflowed := q.status()
+	if !overflowed {
+		return nil
+	}
+	return status.Errorf(codes.ResourceExhausted, "subscriber queue full, %d events dropped", dropped)
+}
+
+// signal notifies a waiter on c without blocking.
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,90 @@
+package pqstream
+
+import (
+	"testing"
+
+	"github.com/tmc/pqstream/pqs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_eventQueue_push(t *testing.T) {
+	tests := []struct {
+		policy      OverflowPolicy
+		limit       int
+		pushes      int
+		wantQueued  []uint64
+		wantDropped int
+		wantCode    codes.Code
+	}{
+		{OverflowDropOldest, 0, 5, []uint64{1, 2, 3, 4, 5}, 0, codes.OK},
+		{OverflowDropOldest, 3, 3, []uint64{1, 2, 3}, 0, codes.OK},
+		{OverflowDropOldest, 3, 5, []uint64{3, 4, 5}, 2, codes.OK},
+		{OverflowDisconnect, 3, 3, []uint64{1, 2, 3}, 0, codes.OK},
+		{OverflowDisconnect, 3, 5, nil, 5, codes.ResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			q := newEventQueue(tt.limit, tt.policy)
+			for i := 1; i <= tt.pushes; i++ {
+				q.push(nil, &pqs.Event{Position: uint64(i)})
+			}
+			var got []uint64
+			for _, e := range q.pop() {
+				got = append(got, e.Position)
+			}
+			if len(got) != len(tt.wantQueued) {
+				t.Fatalf("queued %v, want %v", got, tt.wantQueued)
+			}
+			for i := range got {
+				if got[i] != tt.wantQueued[i] {
+					t.Fatalf("queued %v, want %v", got, tt.wantQueued)
+				}
+			}
+			if dropped, _ := q.status(); dropped != tt.wantDropped {
+				t.Errorf("dropped %v, want %v", dropped, tt.wantDropped)
+			}
+			if code := status.Code(overflowError(q)); code != tt.wantCode {
+				t.Errorf("overflowError() code = %v, want %v", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func Test_eventQueue_block(t *testing.T) {
+	q := newEventQueue(1, OverflowBlock)
+	q.push(nil, &pqs.Event{Position: 1})
+	pushed := make(chan bool)
+	go func() {
+		pushed <- q.push(nil, &pqs.Event{Position: 2})
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("push() did not block on a full queue")
+	default:
+	}
+	if events := q.pop(); len(events) != 1 || events[0].Position != 1 {
+		t.Fatalf("pop() = %v, want the first event", events)
+	}
+	if ok := <-pushed; !ok {
+		t.Fatal("push() = false after the queue was drained")
+	}
+
+	done := make(chan struct{})
+	close(done)
+	if q.push(done, &pqs.Event{Position: 3}) {
+		t.Error("push() = true on a full queue after done was closed")
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, p := range []OverflowPolicy{OverflowBlock, OverflowDropOldest, OverflowDisconnect} {
+		got, err := ParseOverflowPolicy(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParseOverflowPolicy(%q) = %v, %v, want %v", p.String(), got, err, p)
+		}
+	}
+	if _, err := ParseOverflowPolicy("ignore"); err == nil {
+		t.Error("ParseOverflowPolicy(\"ignore\") succeeded, want an error")
+	}
+}
@@ -1,52 +1,342 @@
 package pqstream
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"strings"
 
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/pkg/errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
 	"github.com/tmc/pqstream/pqs"
+
+	ptypes_struct "github.com/golang/protobuf/ptypes/struct"
 )
 
 // FieldRedactions describes how redaction fields are specified.
 // Top level map key is the schema, inner map key is the table and slice is the fields to redact.
+// The fields are deleted, Redactions also describes other strategies.
 type FieldRedactions map[string]map[string][]string
 
+// Redactions describes which fields are redacted and how.
+// Top level map key is the schema, inner map key is the table and slice is the fields to redact.
+// Schemas, tables and fields may be patterns and fields may be nested, see parseRedactionPath.
+type Redactions map[string]map[string][]FieldRedaction
+
+// RedactionStrategy is how a redacted field is protected.
+type RedactionStrategy string
+
+// Redaction strategies.
+const (
+	// RedactDelete removes the field, the default.
+	RedactDelete RedactionStrategy = "delete"
+	// RedactHash replaces the value with its keyed HMAC-SHA256 in hex, so equal values can still be joined on.
+	RedactHash RedactionStrategy = "hash"
+	// RedactMask replaces all but the last Keep characters of the value with '*'.
+	RedactMask RedactionStrategy = "mask"
+	// RedactNull replaces the value with null.
+	RedactNull RedactionStrategy = "null"
+	// RedactReplace replaces the value with Value.
+	RedactReplace RedactionStrategy = "replace"
+)
+
+// FieldRedaction is a field to redact and how. In JSON it is either the field name, which deletes it,
+// or an object such as {"field": "card", "strategy": "mask", "keep": 4}.
+type FieldRedaction struct {
+	Field    string
+	Strategy RedactionStrategy
+	// Keep is the number of trailing characters left unmasked by RedactMask.
+	Keep int
+	// Value is the replacement used by RedactReplace.
+	Value *ptypes_struct.Value
+}
+
+// UnmarshalJSON decodes a field name or a redaction object.
+func (r *FieldRedaction) UnmarshalJSON(b []byte) error {
+	var field string
+	if err := json.Unmarshal(b, &field); err == nil {
+		*r = FieldRedaction{Field: field, Strategy: RedactDelete}
+		return nil
+	}
+	var v struct {
+		Field    string            `json:"field"`
+		Strategy RedactionStrategy `json:"strategy"`
+		Keep     int               `json:"keep"`
+		Value    json.RawMessage   `json:"value"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*r = FieldRedaction{Field: v.Field, Strategy: v.Strategy, Keep: v.Keep}
+	if r.Strategy == "" {
+		r.Strategy = RedactDelete
+	}
+	switch r.Strategy {
+	case RedactDelete, RedactHash, RedactNull:
+	case RedactMask:
+		if r.Keep < 0 {
+			return errors.Errorf("redaction of %s: keep must not be negative", r.Field)
+		}
+	case RedactReplace:
+		r.Value = &ptypes_struct.Value{}
+		if len(v.Value) == 0 {
+			return errors.Errorf("redaction of %s: replace requires a value", r.Field)
+		}
+		if err := jsonpb.UnmarshalString(string(v.Value), r.Value); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("redaction of %s: value", r.Field))
+		}
+	default:
+		return errors.Errorf("redaction of %s: unknown strategy %q", r.Field, r.Strategy)
+	}
+	if r.Field == "" {
+		return errors.New("redaction without a field")
+	}
+	return nil
+}
+
+// WithRedactionKey sets the key used to hash fields with RedactHash.
+func WithRedactionKey(key []byte) ServerOption {
+	return func(s *Server) {
+		s.redactionKey = key
+	}
+}
+
+// redactor applies redactions to the fields of rows.
+type redactor struct {
+	key []byte
+}
+
+// redact applies redactions to the fields of st in place.
+func (rd redactor) redact(st *ptypes_struct.Struct, redactions []FieldRedaction) {
+	if st == nil {
+		return
+	}
+	for _, r := range redactions {
+		elems, err := parseRedactionPath(r.Field)
+		if err != nil {
+			// checked when decoded
+			continue
+		}
+		rd.redactPath(st.Fields, elems, r)
+	}
+}
+
+// value returns the redacted value of v, or nil if the field is removed.
+func (rd redactor) value(r FieldRedaction, v *ptypes_struct.Value) *ptypes_struct.Value {
+	_, null := v.GetKind().(*ptypes_struct.Value_NullValue)
+	switch r.Strategy {
+	case RedactHash:
+		if null {
+			return v
+		}
+		mac := hmac.New(sha256.New, rd.key)
+		mac.Write([]byte(plainText(v)))
+		return stringValue(hex.EncodeToString(mac.Sum(nil)))
+	case RedactMask:
+		if null {
+			return v
+		}
+		text := []rune(plainText(v))
+		for i := 0; i < len(text)-r.Keep; i++ {
+			text[i] = '*'
+		}
+		return stringValue(string(text))
+	case RedactNull:
+		return &ptypes_struct.Value{Kind: &ptypes_struct.Value_NullValue{}}
+	case RedactReplace:
+		return r.Value
+	}
+	return nil
+}
+
+// requiresRedactionKey reports whether any of the redactions configured hash fields.
+func (s *Server) requiresRedactionKey() bool {
+	if s.redactions.requiresKey() {
+		return true
+	}
+	for _, r := range s.redactionProfiles {
+		if r.requiresKey() {
+			return true
+		}
+	}
+	for _, p := range s.principals {
+		if p.Redactions.requiresKey() {
+			return true
+		}
+	}
+	return false
+}
+
+// requiresKey reports whether any field is hashed.
+func (r Redactions) requiresKey() bool {
+	for _, tables := range r {
+		for _, fields := range tables {
+			for _, f := range fields {
+				if f.Strategy == RedactHash {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// plainText returns strings as is and other values as JSON.
+func plainText(v *ptypes_struct.Value) string {
+	if s, ok := v.GetKind().(*ptypes_struct.Value_StringValue); ok {
+		return s.StringValue
+	}
+	return valueText(v)
+}
+
+func stringValue(s string) *ptypes_struct.Value {
+	return &ptypes_struct.Value{Kind: &ptypes_struct.Value_StringValue{StringValue: s}}
+}
+
 // DecodeRedactions returns a FieldRedactions map decoded from redactions specified in json format.
 func DecodeRedactions(r string) (FieldRedactions, error) {
 	rfields := make(FieldRedactions)
 	if err := json.NewDecoder(strings.NewReader(r)).Decode(&rfields); err != nil {
 		return nil, err
 	}
+	if err := rfields.redactions().check(); err != nil {
+		return nil, err
+	}
 
 	return rfields, nil
 }
 
+// ParseRedactions returns Redactions decoded from redactions specified in json format, where each field is
+// either a name or an object choosing the strategy, see FieldRedaction.
+func ParseRedactions(r string) (Redactions, error) {
+	redactions := make(Redactions)
+	if err := json.NewDecoder(strings.NewReader(r)).Decode(&redactions); err != nil {
+		return nil, err
+	}
+	return redactions, nil
+}
+
 // WithFieldRedactions controls which fields are redacted from the feed.
 func WithFieldRedactions(r FieldRedactions) ServerOption {
+	return func(s *Server) {
+		s.redactions = r.redactions()
+	}
+}
+
+// WithRedactions controls which fields are redacted from the feed and how.
+func WithRedactions(r Redactions) ServerOption {
 	return func(s *Server) {
 		s.redactions = r
 	}
 }
 
+// redactions returns the Redactions deleting the fields of r.
+func (r FieldRedactions) redactions() Redactions {
+	redactions := make(Redactions, len(r))
+	for schema, tables := range r {
+		redactions[schema] = make(map[string][]FieldRedaction, len(tables))
+		for table, fields := range tables {
+			for _, f := range fields {
+				redactions[schema][table] = append(redactions[schema][table], FieldRedaction{Field: f, Strategy: RedactDelete})
+			}
+		}
+	}
+	return redactions
+}
+
+// WithRedactionProfiles configures named sets of redactions clients can request in addition to those
+// applied to all clients, i.e. a profile for partner-facing consumers.
+func WithRedactionProfiles(profiles map[string]Redactions) ServerOption {
+	return func(s *Server) {
+		s.redactionProfiles = profiles
+	}
+}
+
+// DecodeRedactionProfiles returns redaction profiles decoded from json format, keyed by profile name.
+func DecodeRedactionProfiles(r string) (map[string]Redactions, error) {
+	profiles := make(map[string]Redactions)
+	if err := json.NewDecoder(strings.NewReader(r)).Decode(&profiles); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// add adds the redactions in o to r.
+func (r Redactions) add(o Redactions) {
+	for schema, tables := range o {
+		if r[schema] == nil {
+			r[schema] = make(map[string][]FieldRedaction, len(tables))
+		}
+		for table, fields := range tables {
+			r[schema][table] = append(r[schema][table], fields...)
+		}
+	}
+}
+
+// subscriberRedactions returns the redactions applied to the events sent to a subscriber in addition to the
+// global ones: those of the caller's principal and of the profiles requested by r.
+func (s *Server) subscriberRedactions(ctx context.Context, r *pqs.ListenRequest) (Redactions, error) {
+	redactions := make(Redactions)
+	if p, ok := ctx.Value(principalKey{}).(*Principal); ok {
+		redactions.add(p.Redactions)
+	}
+	for _, name := range r.RedactionProfiles {
+		profile, ok := s.redactionProfiles[name]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown redaction profile %q", name)
+		}
+		redactions.add(profile)
+	}
+	return redactions, nil
+}
+
+// eventRedaction returns a function that applies the redactions in r to events.
+func (s *Server) eventRedaction(r Redactions) func(*pqs.Event) *pqs.Event {
+	rd := redactor{key: s.redactionKey}
+	return func(e *pqs.Event) *pqs.Event {
+		fields := r.fields(e.Schema, e.Table)
+		if len(fields) == 0 {
+			return e
+		}
+		redacted := *e
+		redacted.Payload = copyStruct(e.Payload)
+		redacted.Changes = copyStruct(e.Changes)
+		redacted.Key = copyStruct(e.Key)
+		redacted.Previous = copyStruct(e.Previous)
+		rd.redact(redacted.Payload, fields)
+		rd.redact(redacted.Changes, fields)
+		rd.redact(redacted.Key, fields)
+		rd.redact(redacted.Previous, fields)
+		return &redacted
+	}
+}
+
+// copyStruct returns a shallow copy of st.
+func copyStruct(st *ptypes_struct.Struct) *ptypes_struct.Struct {
+	if st == nil {
+		return nil
+	}
+	c := &ptypes_struct.Struct{Fields: make(map[string]*ptypes_struct.Value, len(st.Fields))}
+	for k, v := range st.Fields {
+		c.Fields[k] = v
+	}
+	return c
+}
+
 // redactFields search through redactionMap if there's any redacted fields
 // specified that match the fields of the current event.
 func (s *Server) redactFields(e *pqs.RawEvent) {
-	if tables, ok := s.redactions[e.GetSchema()]; ok {
-		if fields, ok := tables[e.GetTable()]; ok {
-			for _, rf := range fields {
-				if e.Payload != nil {
-					if _, ok := e.Payload.Fields[rf]; ok {
-						//remove field from payload
-						delete(e.Payload.Fields, rf)
-					}
-				}
-				if e.Previous != nil {
-					if _, ok := e.Previous.Fields[rf]; ok {
-						//remove field from previous payload
-						delete(e.Previous.Fields, rf)
-					}
-				}
-			}
-		}
+	if fields := s.fieldRedactions().fields(e.GetSchema(), e.GetTable()); len(fields) > 0 {
+		rd := redactor{key: s.redactionKey}
+		rd.redact(e.Payload, fields)
+		rd.redact(e.Previous, fields)
+		rd.redact(e.Key, fields)
 	}
 }
@@ -1,8 +1,14 @@
 package pqstream
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"testing"
 
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
 	google_protobuf "github.com/golang/protobuf/ptypes/struct"
 	"github.com/google/go-cmp/cmp"
 	"github.com/tmc/pqstream/pqs"
@@ -103,7 +109,7 @@ func TestServer_redactFields(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s.redactions = tt.args.redactions
+			s.redactions = tt.args.redactions.redactions()
 			s.redactFields(tt.args.incoming)
 
 			if got := tt.args.incoming; tt.args.expected != nil && !cmp.Equal(got, tt.args.expected) {
@@ -113,33 +119,91 @@ func TestServer_redactFields(t *testing.T) {
 	}
 }
 
-func TestDecodeRedactions(t *testing.T) {
+func TestParseRedactions(t *testing.T) {
 	type args struct {
 		r string
 	}
 	tests := []struct {
 		name    string
 		args    args
-		want    FieldRedactions
+		want    Redactions
 		wantErr bool
 	}{
 		{
 			name: "basic",
 			args: args{r: `{"public":{"users":["first_name","last_name","email"]}}`},
-			want: FieldRedactions{
-				"public": {"users": []string{
+			want: Redactions{
+				"public": {"users": deleted(
 					"first_name",
 					"last_name",
 					"email",
-				},
+				),
 				},
 			},
 			wantErr: false,
 		},
+		{
+			name: "strategies",
+			args: args{r: `{"public":{"users":["password",{"field":"email","strategy":"hash"},{"field":"card","strategy":"mask","keep":4},{"field":"phone","strategy":"null"},{"field":"ssn"}]}}`},
+			want: Redactions{
+				"public": {"users": []FieldRedaction{
+					{Field: "password", Strategy: RedactDelete},
+					{Field: "email", Strategy: RedactHash},
+					{Field: "card", Strategy: RedactMask, Keep: 4},
+					{Field: "phone", Strategy: RedactNull},
+					{Field: "ssn", Strategy: RedactDelete},
+				}},
+			},
+		},
+		{
+			name:    "unknown_strategy",
+			args:    args{r: `{"public":{"users":[{"field":"email","strategy":"encrypt"}]}}`},
+			wantErr: true,
+		},
+		{
+			name:    "replace_without_value",
+			args:    args{r: `{"public":{"users":[{"field":"email","strategy":"replace"}]}}`},
+			wantErr: true,
+		},
+		{
+			name:    "negative_keep",
+			args:    args{r: `{"public":{"users":[{"field":"card","strategy":"mask","keep":-1}]}}`},
+			wantErr: true,
+		},
+		{
+			name:    "missing_field",
+			args:    args{r: `{"public":{"users":[{"strategy":"hash"}]}}`},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := DecodeRedactions(tt.args.r)
+			got, err := ParseRedactions(tt.args.r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseRedactions() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("ParseRedactions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeRedactions(t *testing.T) {
+	tests := []struct {
+		name    string
+		r       string
+		want    FieldRedactions
+		wantErr bool
+	}{
+		{"basic", `{"public":{"users":["first_name","email"]}}`, FieldRedactions{"public": {"users": {"first_name", "email"}}}, false},
+		{"strategy", `{"public":{"users":[{"field":"email","strategy":"hash"}]}}`, nil, true},
+		{"invalid_path", `{"public":{"users":["profile..ssn"]}}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeRedactions(tt.r)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("DecodeRedactions() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -150,3 +214,113 @@ func TestDecodeRedactions(t *testing.T) {
 		})
 	}
 }
+
+func Test_eventRedaction(t *testing.T) {
+	str := func(s string) *google_protobuf.Value {
+		return &google_protobuf.Value{Kind: &google_protobuf.Value_StringValue{StringValue: s}}
+	}
+	event := &pqs.Event{
+		Schema: "public",
+		Table:  "users",
+		Payload: &google_protobuf.Struct{Fields: map[string]*google_protobuf.Value{
+			"name":  str("someone"),
+			"email": str("someone@corp.com"),
+		}},
+		Changes: &google_protobuf.Struct{Fields: map[string]*google_protobuf.Value{
+			"email": str("someone@corp.com"),
+		}},
+		Key: &google_protobuf.Struct{Fields: map[string]*google_protobuf.Value{
+			"email": str("someone@corp.com"),
+		}},
+	}
+	redact := (&Server{}).eventRedaction(Redactions{"public": {"users": deleted("email")}})
+
+	got := applyShared(t, redact, event)
+	want := &pqs.Event{
+		Schema:  "public",
+		Table:   "users",
+		Payload: &google_protobuf.Struct{Fields: map[string]*google_protobuf.Value{"name": str("someone")}},
+		Changes: &google_protobuf.Struct{Fields: map[string]*google_protobuf.Value{}},
+		Key:     &google_protobuf.Struct{Fields: map[string]*google_protobuf.Value{}},
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("eventRedaction() = %v, want %v", got, want)
+	}
+	other := &pqs.Event{Schema: "public", Table: "notes", Payload: event.Payload}
+	if got := redact(other); got != other {
+		t.Error("eventRedaction() copied an event of a table without redactions")
+	}
+}
+
+func TestServer_subscriberRedactions(t *testing.T) {
+	s := &Server{}
+	WithRedactionProfiles(map[string]Redactions{
+		"partner": {"public": {"users": deleted("email")}},
+		"minimal": {"public": {"users": deleted("phone"), "orders": deleted("address")}},
+	})(s)
+	principal := &Principal{Name: "analytics", Redactions: Redactions{"public": {"users": deleted("ssn")}}}
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		profiles []string
+		want     Redactions
+		wantErr  bool
+	}{
+		{"none", context.Background(), nil, Redactions{}, false},
+		{"profile", context.Background(), []string{"partner"}, Redactions{"public": {"users": deleted("email")}}, false},
+		{"profiles", context.Background(), []string{"partner", "minimal"}, Redactions{"public": {"users": deleted("email", "phone"), "orders": deleted("address")}}, false},
+		{"principal", context.WithValue(context.Background(), principalKey{}, principal), []string{"partner"}, Redactions{"public": {"users": deleted("ssn", "email")}}, false},
+		{"unknown profile", context.Background(), []string{"nope"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.subscriberRedactions(tt.ctx, &pqs.ListenRequest{RedactionProfiles: tt.profiles})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("subscriberRedactions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("subscriberRedactions() = %v", diff)
+			}
+		})
+	}
+}
+
+// deleted returns redactions deleting fields.
+func deleted(fields ...string) []FieldRedaction {
+	r := make([]FieldRedaction, len(fields))
+	for i, f := range fields {
+		r[i] = FieldRedaction{Field: f, Strategy: RedactDelete}
+	}
+	return r
+}
+
+func Test_redactor(t *testing.T) {
+	redactions, err := ParseRedactions(`{"public":{"users":[
+		"password",
+		{"field":"email","strategy":"hash"},
+		{"field":"card","strategy":"mask","keep":4},
+		{"field":"pin","strategy":"mask"},
+		{"field":"phone","strategy":"null"},
+		{"field":"notes","strategy":"replace","value":"[redacted]"},
+		{"field":"missing","strategy":"null"}
+	]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := &google_protobuf.Struct{}
+	if err := jsonpb.UnmarshalString(`{"id":1,"password":"secret","email":"someone@corp.com","card":4111111111111111,"pin":"1234","phone":"555-0100","notes":"likes cats"}`, row); err != nil {
+		t.Fatal(err)
+	}
+	redactor{key: []byte("key")}.redact(row, redactions["public"]["users"])
+	got, err := (&jsonpb.Marshaler{}).MarshalToString(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("someone@corp.com"))
+	want := `{"card":"************1111","email":"` + hex.EncodeToString(mac.Sum(nil)) + `","id":1,"notes":"[redacted]","phone":null,"pin":"****"}`
+	if got != want {
+		t.Errorf("redact() = %v, want %v", got, want)
+	}
+}
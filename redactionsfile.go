@@ -0,0 +1,130 @@
+package pqstream
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// LoadRedactionsFile reads redactions from a file in JSON or YAML format, i.e.
+//
+//	public:
+//	  users: [password, {field: email, strategy: hash}]
+func LoadRedactionsFile(name string) (Redactions, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "read redactions")
+	}
+	// JSON is valid YAML.
+	j, err := yaml.YAMLToJSON(b)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("parse redactions %s", name))
+	}
+	var r Redactions
+	if err := r.UnmarshalJSON(j); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("decode redactions %s", name))
+	}
+	return r, nil
+}
+
+// SetRedactions replaces the redactions applied for all clients while the server is running. Redactions
+// of tables or columns that do not exist are logged as warnings, as they may be mistakes.
+func (s *Server) SetRedactions(r Redactions) error {
+	if len(s.redactionKey) == 0 && r.requiresKey() {
+		return errors.New("hash redactions require a redaction key")
+	}
+	if err := s.updateTableFunctions(r); err != nil {
+		return errors.Wrap(err, "update trigger functions")
+	}
+	if warnings, err := s.checkRedactions(r); err != nil {
+		s.logger.WithError(err).Warnln("could not check redactions against the tables")
+	} else {
+		for _, w := range warnings {
+			s.logger.WithField("redaction", w).Warnln("redaction does not match any column")
+		}
+	}
+	s.redactionsMu.Lock()
+	s.redactions = r
+	s.redactionsMu.Unlock()
+	return nil
+}
+
+// fieldRedactions returns the redactions applied for all clients.
+func (s *Server) fieldRedactions() Redactions {
+	s.redactionsMu.RLock()
+	defer s.redactionsMu.RUnlock()
+	return s.redactions
+}
+
+// checkRedactions returns the redactions that match no column of the managed tables.
+func (s *Server) checkRedactions(r Redactions) ([]string, error) {
+	tableNames, err := s.tableNames()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[table][]string, len(tableNames))
+	for _, t := range tableNames {
+		if columns[t], err = s.columnNames(t); err != nil {
+			return nil, err
+		}
+	}
+	return unmatchedRedactions(r, columns), nil
+}
+
+// columnNames returns the columns of t.
+func (s *Server) columnNames(t table) ([]string, error) {
+	rows, err := s.db.Query(sqlQueryColumns, t.schema, t.name)
+	if err != nil {
+		return nil, errors.Wrap(err, "query columns")
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, errors.Wrap(err, "columns scan")
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+// unmatchedRedactions returns the redactions, as "schema.table.field", whose field matches no column of
+// a table they apply to.
+func unmatchedRedactions(r Redactions, columns map[table][]string) []string {
+	var unmatched []string
+	for _, schema := range sortedKeys(r) {
+		for _, tp := range sortedTables(r[schema]) {
+			for _, f := range r[schema][tp] {
+				elems, err := parseRedactionPath(f.Field)
+				if err != nil {
+					continue
+				}
+				if !matchesColumn(schema, tp, elems[0].name, columns) {
+					unmatched = append(unmatched, schema+"."+tp+"."+f.Field)
+				}
+			}
+		}
+	}
+	return unmatched
+}
+
+func matchesColumn(schema, tablePattern, column string, columns map[table][]string) bool {
+	for t, cols := range columns {
+		if ok, _ := path.Match(schema, t.schema); !ok {
+			continue
+		}
+		if ok, _ := path.Match(tablePattern, t.name); !ok {
+			continue
+		}
+		for _, c := range cols {
+			if ok, _ := path.Match(column, c); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package pqstream
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadRedactionsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redactions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	want := Redactions{"public": {"users": []FieldRedaction{
+		{Field: "password", Strategy: RedactDelete},
+		{Field: "email", Strategy: RedactHash},
+	}}}
+	tests := []struct {
+		name     string
+		contents string
+		want     Redactions
+		wantErr  bool
+	}{
+		{"json", `{"public":{"users":["password",{"field":"email","strategy":"hash"}]}}`, want, false},
+		{"yaml", "public:\n  users:\n    - password\n    - field: email\n      strategy: hash\n", want, false},
+		{"invalid yaml", "public: [", nil, true},
+		{"invalid strategy", "public:\n  users:\n    - {field: email, strategy: encrypt}\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(dir, tt.name)
+			if err := ioutil.WriteFile(name, []byte(tt.contents), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadRedactionsFile(name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadRedactionsFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("LoadRedactionsFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if _, err := LoadRedactionsFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("LoadRedactionsFile() of a missing file succeeded, want error")
+	}
+}
+
+func Test_unmatchedRedactions(t *testing.T) {
+	r, err := ParseRedactions(`{
+		"public": {"users": ["email", "profile.ssn", "nickname"], "orders": ["total"], "*": ["*_token"]},
+		"sales": {"*": ["email"]}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := map[table][]string{
+		{"public", "users"}:  {"id", "email", "profile", "api_token"},
+		{"public", "orders"}: {"id", "amount"},
+	}
+	want := []string{"public.orders.total", "public.users.nickname", "sales.*.email"}
+	if got := unmatchedRedactions(r, columns); !cmp.Equal(got, want) {
+		t.Errorf("unmatchedRedactions() = %v, want %v", got, want)
+	}
+}
+
+func TestServer_SetRedactions(t *testing.T) {
+	hashed := Redactions{"public": {"notes": []FieldRedaction{{Field: "note", Strategy: RedactHash}}}}
+	if err := (&Server{}).SetRedactions(hashed); err == nil {
+		t.Error("SetRedactions() with hash redactions and no key succeeded, want error")
+	}
+
+	db := dbOrSkip(t)
+	cs, cleanup := testDBConn(t, db, "set_redactions")
+	defer cleanup()
+	s, err := NewServer(cs, WithLogger(loggerFromT(t)), WithRedactionKey([]byte("key")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.SetRedactions(hashed); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.fieldRedactions(); !cmp.Equal(got, hashed) {
+		t.Errorf("fieldRedactions() = %v, want %v", got, hashed)
+	}
+}
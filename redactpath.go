@@ -0,0 +1,154 @@
+package pqstream
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	ptypes_struct "github.com/golang/protobuf/ptypes/struct"
+)
+
+// Redacted fields are paths of field names separated by '.', where a name followed by [*] selects each
+// element of a list, i.e. "profile.ssn" or "addresses[*].street". Names, like the schemas and tables of
+// Redactions, may be glob patterns as understood by path.Match, i.e. "*_token".
+
+// redactionPathElem is an element of a redaction path.
+type redactionPathElem struct {
+	name string
+	// each element of the list selected by name
+	each bool
+}
+
+// parseRedactionPath parses the path of a redacted field.
+func parseRedactionPath(p string) ([]redactionPathElem, error) {
+	var elems []redactionPathElem
+	for _, part := range strings.Split(p, ".") {
+		e := redactionPathElem{name: strings.TrimSuffix(part, "[*]")}
+		e.each = e.name != part
+		if e.name == "" {
+			return nil, errors.Errorf("invalid redaction path %q", p)
+		}
+		if _, err := path.Match(e.name, ""); err != nil {
+			return nil, errors.Wrap(err, "redaction path "+p)
+		}
+		elems = append(elems, e)
+	}
+	return elems, nil
+}
+
+// UnmarshalJSON decodes redactions and checks their patterns and paths.
+func (r *Redactions) UnmarshalJSON(b []byte) error {
+	var m map[string]map[string][]FieldRedaction
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	if err := Redactions(m).check(); err != nil {
+		return err
+	}
+	*r = m
+	return nil
+}
+
+// check checks the patterns and paths of r.
+func (r Redactions) check() error {
+	for schema, tables := range r {
+		if _, err := path.Match(schema, ""); err != nil {
+			return errors.Wrap(err, "redaction schema "+schema)
+		}
+		for table, fields := range tables {
+			if _, err := path.Match(table, ""); err != nil {
+				return errors.Wrap(err, "redaction table "+table)
+			}
+			for _, f := range fields {
+				if _, err := parseRedactionPath(f.Field); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// fields returns the redactions for the table, including those of matching patterns, in a stable order.
+func (r Redactions) fields(schema, table string) []FieldRedaction {
+	var fields []FieldRedaction
+	for _, sp := range sortedKeys(r) {
+		if ok, _ := path.Match(sp, schema); !ok {
+			continue
+		}
+		tables := r[sp]
+		for _, tp := range sortedTables(tables) {
+			if ok, _ := path.Match(tp, table); ok {
+				fields = append(fields, tables[tp]...)
+			}
+		}
+	}
+	return fields
+}
+
+func sortedKeys(r Redactions) []string {
+	keys := make([]string, 0, len(r))
+	for k := range r {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedTables(tables map[string][]FieldRedaction) []string {
+	keys := make([]string, 0, len(tables))
+	for k := range tables {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// redactPath applies r to the fields selected by elems. Nested values are replaced rather than modified
+// as they may be shared with other events.
+func (rd redactor) redactPath(fields map[string]*ptypes_struct.Value, elems []redactionPathElem, r FieldRedaction) {
+	e := elems[0]
+	for name, v := range fields {
+		if ok, _ := path.Match(e.name, name); !ok {
+			continue
+		}
+		if v, keep := rd.redactValue(v, e.each, elems[1:], r); keep {
+			fields[name] = v
+		} else {
+			delete(fields, name)
+		}
+	}
+}
+
+// redactValue returns v with r applied to the values selected by the rest of the path, and whether v is kept.
+func (rd redactor) redactValue(v *ptypes_struct.Value, each bool, rest []redactionPathElem, r FieldRedaction) (*ptypes_struct.Value, bool) {
+	if each {
+		list, ok := v.GetKind().(*ptypes_struct.Value_ListValue)
+		if !ok || list.ListValue == nil {
+			return v, true
+		}
+		values := make([]*ptypes_struct.Value, 0, len(list.ListValue.Values))
+		for _, el := range list.ListValue.Values {
+			if el, keep := rd.redactValue(el, false, rest, r); keep {
+				values = append(values, el)
+			}
+		}
+		return &ptypes_struct.Value{Kind: &ptypes_struct.Value_ListValue{
+			ListValue: &ptypes_struct.ListValue{Values: values},
+		}}, true
+	}
+	if len(rest) == 0 {
+		v = rd.value(r, v)
+		return v, v != nil
+	}
+	st, ok := v.GetKind().(*ptypes_struct.Value_StructValue)
+	if !ok || st.StructValue == nil {
+		return v, true
+	}
+	c := copyStruct(st.StructValue)
+	rd.redactPath(c.Fields, rest, r)
+	return &ptypes_struct.Value{Kind: &ptypes_struct.Value_StructValue{StructValue: c}}, true
+}
@@ -0,0 +1,110 @@
+package pqstream
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/google/go-cmp/cmp"
+
+	ptypes_struct "github.com/golang/protobuf/ptypes/struct"
+)
+
+func Test_parseRedactionPath(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []redactionPathElem
+		wantErr bool
+	}{
+		{"email", []redactionPathElem{{name: "email"}}, false},
+		{"profile.ssn", []redactionPathElem{{name: "profile"}, {name: "ssn"}}, false},
+		{"addresses[*].street", []redactionPathElem{{name: "addresses", each: true}, {name: "street"}}, false},
+		{"*_token", []redactionPathElem{{name: "*_token"}}, false},
+		{"profile..ssn", nil, true},
+		{"[*]", nil, true},
+		{"bad[", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseRedactionPath(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRedactionPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !cmp.Equal(got, tt.want, cmp.AllowUnexported(redactionPathElem{})) {
+				t.Errorf("parseRedactionPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactions_fields(t *testing.T) {
+	r, err := ParseRedactions(`{
+		"public": {"users": ["email"], "user*": ["phone"]},
+		"*": {"*": ["*_token"]},
+		"audit": {"users": ["ip"]}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := func(fields []FieldRedaction) []string {
+		var n []string
+		for _, f := range fields {
+			n = append(n, f.Field)
+		}
+		return n
+	}
+	tests := []struct {
+		schema, table string
+		want          []string
+	}{
+		{"public", "users", []string{"*_token", "phone", "email"}},
+		{"public", "user_roles", []string{"*_token", "phone"}},
+		{"sales", "orders", []string{"*_token"}},
+		{"audit", "users", []string{"*_token", "ip"}},
+	}
+	for _, tt := range tests {
+		if got := names(r.fields(tt.schema, tt.table)); !cmp.Equal(got, tt.want) {
+			t.Errorf("fields(%q, %q) = %v, want %v", tt.schema, tt.table, got, tt.want)
+		}
+	}
+
+	if _, err := ParseRedactions(`{"public": {"users[": ["email"]}}`); err == nil {
+		t.Error("ParseRedactions() with an invalid table pattern succeeded, want error")
+	}
+	if _, err := ParseRedactions(`{"public": {"users": ["profile..ssn"]}}`); err == nil {
+		t.Error("ParseRedactions() with an invalid path succeeded, want error")
+	}
+}
+
+func Test_redactor_paths(t *testing.T) {
+	redactions, err := ParseRedactions(`{"public": {"users": [
+		"profile.ssn",
+		{"field": "profile.dob", "strategy": "null"},
+		"addresses[*].street",
+		{"field": "phones[*]", "strategy": "mask", "keep": 2},
+		"*_token",
+		"missing.field",
+		"name.first"
+	]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const input = `{"addresses":[{"city":"Oslo","street":"Main St 1"},{"city":"Bergen"}],"api_token":"t1","name":"someone","phones":["5550100"],"profile":{"dob":"1990-01-01","lang":"en","ssn":"123-45-6789"},"refresh_token":"t2"}`
+	row := &ptypes_struct.Struct{}
+	if err := jsonpb.UnmarshalString(input, row); err != nil {
+		t.Fatal(err)
+	}
+	shared := copyStruct(row)
+	redactor{}.redact(row, redactions.fields("public", "users"))
+	got, err := (&jsonpb.Marshaler{}).MarshalToString(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"addresses":[{"city":"Oslo"},{"city":"Bergen"}],"name":"someone","phones":["*****00"],"profile":{"dob":null,"lang":"en"}}`
+	if got != want {
+		t.Errorf("redact() = %v, want %v", got, want)
+	}
+	// nested values may be shared with other events and are replaced rather than modified.
+	if got, _ := (&jsonpb.Marshaler{}).MarshalToString(shared); got != input {
+		t.Errorf("redact() modified nested values to %v", got)
+	}
+}
@@ -0,0 +1,122 @@
+package pqstream
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/tmc/pqstream/pqs"
+)
+
+const defaultReplayBufferSize = 1024
+
+// WithReplayBufferSize controls how many recent events are retained for clients resuming a stream.
+func WithReplayBufferSize(n int) ServerOption {
+	return func(s *Server) {
+		s.replay = newReplayBuffer(n)
+	}
+}
+
+// newEpoch returns a random epoch, which tells the positions assigned by different runs of the server apart.
+func newEpoch() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// durable reports whether changes are read from the outbox or a replication slot, which keep them while the server
+// is not running. The epoch and position are then stored in the database, so streams can be resumed across restarts.
+func (s *Server) durable() bool {
+	return s.outbox || s.slot != ""
+}
+
+// loadPosition creates the table storing the epoch and position if needed and continues the stream stored in it.
+func (s *Server) loadPosition() error {
+	if _, err := s.db.Exec(sqlCreatePosition); err != nil {
+		return errors.Wrap(err, "create position")
+	}
+	if _, err := s.db.Exec(sqlInitPosition, s.epoch); err != nil {
+		return errors.Wrap(err, "init position")
+	}
+	if err := s.db.QueryRow(sqlQueryPosition).Scan(&s.epoch, &s.position); err != nil {
+		return errors.Wrap(err, "query position")
+	}
+	s.replay.reset(s.position)
+	return nil
+}
+
+// savePosition stores the position of the last event dispatched.
+func (s *Server) savePosition(position uint64) error {
+	if _, err := s.db.Exec(sqlSavePosition, position); err != nil {
+		return errors.Wrap(err, "store position")
+	}
+	return nil
+}
+
+// flush stores the position of the events dispatched since the last flush with save and then sends them, so that
+// a position is never assigned again after a restart. Events whose position is stored but that were not sent
+// before the server stopped are reported as no longer available to resuming clients.
+func (s *Server) flush(subscribers map[*subscription]bool, save func(position uint64) error) error {
+	if len(s.unsent) == 0 {
+		return nil
+	}
+	if err := save(s.position); err != nil {
+		return err
+	}
+	for _, e := range s.unsent {
+		s.send(subscribers, e)
+	}
+	s.unsent = nil
+	return nil
+}
+
+// replayBuffer retains the most recently emitted events, indexed by their position.
+type replayBuffer struct {
+	events []*pqs.Event
+	last   uint64
+	// number of events retained, at most len(events)
+	n int
+}
+
+func newReplayBuffer(size int) *replayBuffer {
+	if size < 0 {
+		size = 0
+	}
+	return &replayBuffer{events: make([]*pqs.Event, size)}
+}
+
+// add records e, evicting the oldest event once the buffer is full. Positions must be consecutive.
+func (b *replayBuffer) add(e *pqs.Event) {
+	b.last = e.Position
+	if len(b.events) > 0 {
+		b.events[e.Position%uint64(len(b.events))] = e
+	}
+	if b.n < len(b.events) {
+		b.n++
+	}
+}
+
+// reset discards the retained events, continuing after position.
+func (b *replayBuffer) reset(position uint64) {
+	b.last, b.n = position, 0
+}
+
+// since returns the events after position in order.
+func (b *replayBuffer) since(position uint64) ([]*pqs.Event, error) {
+	if position > b.last {
+		return nil, status.Errorf(codes.InvalidArgument, "resume position %d is ahead of the stream (at %d)", position, b.last)
+	}
+	if b.last-position > uint64(b.n) {
+		return nil, status.Errorf(codes.OutOfRange, "resume position %d is no longer available (oldest is %d)", position, b.last-uint64(b.n)+1)
+	}
+	var events []*pqs.Event
+	for p := position + 1; p <= b.last; p++ {
+		events = append(events, b.events[p%uint64(len(b.events))])
+	}
+	return events, nil
+}
@@ -0,0 +1,135 @@
+package pqstream
+
+import (
+	"errors"
+
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tmc/pqstream/pqs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_replayBuffer_since(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		start    uint64
+		nEvents  int
+		position uint64
+		want     []uint64
+		wantCode codes.Code
+	}{
+		{"empty", 4, 0, 0, 0, nil, codes.OK},
+		{"ahead", 4, 0, 2, 3, nil, codes.InvalidArgument},
+		{"current", 4, 0, 2, 2, nil, codes.OK},
+		{"partial", 4, 0, 3, 1, []uint64{2, 3}, codes.OK},
+		{"wrapped", 4, 0, 10, 6, []uint64{7, 8, 9, 10}, codes.OK},
+		{"aged_out", 4, 0, 10, 5, nil, codes.OutOfRange},
+		{"disabled", 0, 0, 10, 9, nil, codes.OutOfRange},
+		{"disabled_current", 0, 0, 10, 10, nil, codes.OK},
+		{"reset", 4, 10, 2, 10, []uint64{11, 12}, codes.OK},
+		{"reset_partial", 4, 10, 2, 11, []uint64{12}, codes.OK},
+		{"before_reset", 4, 10, 2, 9, nil, codes.OutOfRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newReplayBuffer(tt.size)
+			b.reset(tt.start)
+			for i := 1; i <= tt.nEvents; i++ {
+				b.add(&pqs.Event{Position: tt.start + uint64(i)})
+			}
+			events, err := b.since(tt.position)
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("replayBuffer.since(%v) error = %v, want code %v", tt.position, err, tt.wantCode)
+			}
+			var got []uint64
+			for _, e := range events {
+				got = append(got, e.Position)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("replayBuffer.since(%v) = %v, want %v", tt.position, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_addSubscriber(t *testing.T) {
+	tests := []struct {
+		name       string
+		resumeFrom uint64
+		epoch      string
+		want       []uint64
+		wantCode   codes.Code
+	}{
+		{"live", 0, "", nil, codes.OK},
+		{"resume", 1, "a", []uint64{2, 3}, codes.OK},
+		{"restarted", 1, "b", nil, codes.OutOfRange},
+		{"no_epoch", 1, "", nil, codes.OutOfRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{epoch: "a", replay: newReplayBuffer(4)}
+			for i := 0; i < 3; i++ {
+				s.dispatch(nil, &pqs.Event{})
+			}
+			var got []uint64
+			errc := make(chan error, 1)
+			subscribers := map[*subscription]bool{}
+			s.addSubscriber(subscribers, &subscription{resumeFrom: tt.resumeFrom, resumeEpoch: tt.epoch, errc: errc, fn: func(e *pqs.Event) bool {
+				if e.Epoch != s.epoch {
+					t.Errorf("event of epoch %q, want %q", e.Epoch, s.epoch)
+				}
+				got = append(got, e.Position)
+				return true
+			}})
+			var err error
+			select {
+			case err = <-errc:
+			default:
+			}
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("addSubscriber() error = %v, want code %v", err, tt.wantCode)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("addSubscriber() replayed %v, want %v", got, tt.want)
+			}
+			if subscribed := len(subscribers) == 1; subscribed != (tt.wantCode == codes.OK) {
+				t.Errorf("addSubscriber() subscribed = %v, want %v", subscribed, tt.wantCode == codes.OK)
+			}
+		})
+	}
+}
+
+func TestServer_flush(t *testing.T) {
+	s := &Server{outbox: true, epoch: "a", replay: newReplayBuffer(4)}
+	var got []uint64
+	subscribers := map[*subscription]bool{
+		{fn: func(e *pqs.Event) bool {
+			got = append(got, e.Position)
+			return true
+		}}: true,
+	}
+	s.dispatch(subscribers, &pqs.Event{})
+	s.dispatch(subscribers, &pqs.Event{})
+	if len(got) != 0 {
+		t.Fatalf("dispatch() sent %v before the position was stored", got)
+	}
+	if err := s.flush(subscribers, func(uint64) error { return errors.New("failed") }); err == nil {
+		t.Fatal("flush() error = nil, want the error storing the position")
+	}
+	if len(got) != 0 {
+		t.Fatalf("flush() sent %v although storing the position failed", got)
+	}
+	var saved uint64
+	if err := s.flush(subscribers, func(position uint64) error {
+		saved = position
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if saved != 2 || !cmp.Equal(got, []uint64{1, 2}) {
+		t.Errorf("flush() stored %v and sent %v, want 2 and [1 2]", saved, got)
+	}
+}
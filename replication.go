@@ -0,0 +1,157 @@
+package pqstream
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/tmc/pqstream/pqs"
+)
+
+const (
+	publication                    = "pqstream"
+	replicationBatchSize           = 1000
+	defaultReplicationPollInterval = time.Second
+)
+
+// WithLogicalReplication configures the server to read changes from the named logical replication slot
+// (using the pgoutput plugin) instead of from trigger notifications.
+//
+// Changes are retained by the slot while the server is not running or no subscriber is connected, so none are
+// lost between restarts.
+func WithLogicalReplication(slot string) ServerOption {
+	return func(s *Server) {
+		s.slot = slot
+	}
+}
+
+// installReplication creates or updates the publication for tables and makes sure the replication slot exists.
+func (s *Server) installReplication(tables []table) error {
+	quoted := make([]string, len(tables))
+	for i, t := range tables {
+		quoted[i] = t.quoted()
+	}
+	var n int
+	if err := s.db.QueryRow(sqlQueryPublication, publication).Scan(&n); err != nil {
+		return errors.Wrap(err, "query publication")
+	}
+	q := sqlCreatePublication
+	if n > 0 {
+		q = sqlAlterPublication
+	}
+	if _, err := s.db.Exec(fmt.Sprintf(q, pq.QuoteIdentifier(publication), strings.Join(quoted, ", "))); err != nil {
+		return errors.Wrap(err, "publication")
+	}
+	if err := s.db.QueryRow(sqlQueryReplicationSlot, s.slot).Scan(&n); err != nil {
+		return errors.Wrap(err, "query replication slot")
+	}
+	if n == 0 {
+		if _, err := s.db.Exec(sqlCreateReplicationSlot, s.slot); err != nil {
+			return errors.Wrap(err, "create replication slot")
+		}
+	}
+	return nil
+}
+
+// RemoveReplicationSlot drops the replication slot, publication and stored position, discarding any changes not yet
+// streamed.
+func (s *Server) RemoveReplicationSlot() error {
+	if _, err := s.db.Exec(sqlDropReplicationSlot, s.slot); err != nil {
+		return errors.Wrap(err, "drop replication slot")
+	}
+	if _, err := s.db.Exec(fmt.Sprintf(sqlDropPublication, pq.QuoteIdentifier(publication))); err != nil {
+		return errors.Wrap(err, "drop publication")
+	}
+	if _, err := s.db.Exec(sqlDropPosition); err != nil {
+		return errors.Wrap(err, "drop position")
+	}
+	return nil
+}
+
+// replicationChange is an event read from the replication slot or, at the end of a batch, a request to flush the
+// events dispatched. The result of the flush is sent on done and the slot is only advanced if it succeeded.
+type replicationChange struct {
+	event *pqs.RawEvent
+	done  chan error
+}
+
+// consumeReplicationSlot polls the replication slot and sends decoded events to c until ctx is done.
+func (s *Server) consumeReplicationSlot(ctx context.Context, c chan<- replicationChange) error {
+	d := newPgoutputDecoder()
+	for {
+		n, err := s.readReplicationSlot(ctx, d, c)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(s.replicationPollInterval):
+		}
+	}
+}
+
+// readReplicationSlot reads a batch of changes from the replication slot and only consumes them from
+// the slot once they have all been sent and their position stored, so changes are delivered at least once.
+func (s *Server) readReplicationSlot(ctx context.Context, d *pgoutputDecoder, c chan<- replicationChange) (int, error) {
+	rows, err := s.db.QueryContext(ctx, sqlPeekReplicationSlot, s.slot, replicationBatchSize, publication)
+	if err != nil {
+		return 0, errors.Wrap(err, "peek replication slot")
+	}
+	defer rows.Close()
+	var (
+		n   int
+		lsn string
+	)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&lsn, &data); err != nil {
+			return n, errors.Wrap(err, "replication scan")
+		}
+		events, err := d.decode(data)
+		if err != nil {
+			return n, errors.Wrap(err, fmt.Sprintf("decode change at %s", lsn))
+		}
+		for _, e := range events {
+			select {
+			case <-ctx.Done():
+				return n, nil
+			case c <- replicationChange{event: e}:
+			}
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, errors.Wrap(err, "peek replication slot")
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	done := make(chan error, 1)
+	select {
+	case <-ctx.Done():
+		return n, nil
+	case c <- replicationChange{done: done}:
+	}
+	select {
+	case <-ctx.Done():
+		return n, nil
+	case err := <-done:
+		if err != nil {
+			return n, err
+		}
+	}
+	if _, err := s.db.ExecContext(ctx, sqlAdvanceReplicationSlot, s.slot, lsn, publication); err != nil {
+		return n, errors.Wrap(err, "advance replication slot")
+	}
+	return n, nil
+}
@@ -1,14 +1,21 @@
 package pqstream
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"fmt"
+	"os"
 	"regexp"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/ptypes"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/lib/pq"
 	"github.com/pkg/errors"
@@ -22,14 +29,19 @@ const (
 	maxReconnectInterval = 10 * time.Second
 	defaultPingInterval  = 9 * time.Second
 	channel              = "pqstream_notify"
-
-	fallbackIDColumnType = "integer" // TODO(tmc) parameterize
 )
 
 // subscription
 type subscription struct {
-	// while fn returns true the subscription will stay active
+	// while fn returns true the subscription will stay active. Events are shared with the other subscriptions
+	// and the replay buffer, so fn copies rather than modifies them.
 	fn func(*pqs.Event) bool
+	// if non-zero, retained events after this position are passed to fn before live events
+	resumeFrom uint64
+	// epoch of the events resumeFrom refers to
+	resumeEpoch string
+	// receives an error if the subscription cannot be started
+	errc chan error
 }
 
 // Server implements PQStreamServer and manages both client connections and database event monitoring.
@@ -39,11 +51,48 @@ type Server struct {
 	db     *sql.DB
 	ctx    context.Context
 
-	tableRe *regexp.Regexp
+	tableRe  *regexp.Regexp
+	schemaRe *regexp.Regexp
 
 	listenerPingInterval time.Duration
 	subscribe            chan *subscription
-	redactions           FieldRedactions
+	redactionsMu         sync.RWMutex
+	redactions           Redactions
+	principals           Principals
+	redactionProfiles    map[string]Redactions
+	redactionKey         []byte
+	triggerRedactions    bool
+
+	queueSize      int
+	overflowPolicy OverflowPolicy
+
+	slot                    string
+	replicationPollInterval time.Duration
+	outbox                  bool
+	payloadTable            bool
+	commitTrigger           bool
+
+	// tables with change capture set up, nil until InstallTriggers succeeds
+	tables            map[table]bool
+	reconcileInterval time.Duration
+
+	// transaction whose events are being received
+	tx *pendingTransaction
+
+	// identity of the database and of this server included in events
+	database     string
+	databaseHost string
+	hostname     string
+
+	// position of the last emitted event and the buffer of recent events. Positions restart with each
+	// epoch, which is chosen when the server is created, or stored in the database if durable.
+	epoch    string
+	position uint64
+	replay   *replayBuffer
+	// events dispatched but not yet sent as their position is not stored yet, see flush.
+	unsent []*pqs.Event
+
+	health healthStatus
 }
 
 // statically assert that Server satisfies pqs.PQStreamServer
@@ -59,6 +108,13 @@ func WithTableRegexp(re *regexp.Regexp) ServerOption {
 	}
 }
 
+// WithSchemaRegexp controls which schemas tables are managed in. By default only the public schema is.
+func WithSchemaRegexp(re *regexp.Regexp) ServerOption {
+	return func(s *Server) {
+		s.schemaRe = re
+	}
+}
+
 // WithLogger allows attaching a custom logger.
 func WithLogger(l logrus.FieldLogger) ServerOption {
 	return func(s *Server) {
@@ -77,10 +133,14 @@ func WithContext(ctx context.Context) ServerOption {
 func NewServer(connectionString string, opts ...ServerOption) (*Server, error) {
 	s := &Server{
 		subscribe:  make(chan *subscription),
-		redactions: make(FieldRedactions),
-
-		ctx:                  context.Background(),
-		listenerPingInterval: defaultPingInterval,
+		redactions: make(Redactions),
+		replay:     newReplayBuffer(defaultReplayBufferSize),
+		queueSize:  defaultSubscriberQueueSize,
+
+		ctx:                     context.Background(),
+		listenerPingInterval:    defaultPingInterval,
+		replicationPollInterval: defaultReplicationPollInterval,
+		reconcileInterval:       defaultTableReconcileInterval,
 	}
 	for _, o := range opts {
 		o(s)
@@ -88,6 +148,20 @@ func NewServer(connectionString string, opts ...ServerOption) (*Server, error) {
 	if s.logger == nil {
 		s.logger = logrus.StandardLogger()
 	}
+	if s.queueSize < 0 {
+		return nil, errors.Errorf("invalid subscriber queue size %d", s.queueSize)
+	}
+	if len(s.redactionKey) == 0 && s.requiresRedactionKey() {
+		return nil, errors.New("hash redactions require a redaction key")
+	}
+	if err := s.principals.compile(); err != nil {
+		return nil, err
+	}
+	epoch, err := newEpoch()
+	if err != nil {
+		return nil, errors.Wrap(err, "epoch")
+	}
+	s.epoch = epoch
 	db, err := sql.Open("postgres", connectionString)
 	if err != nil {
 		return nil, err
@@ -95,8 +169,20 @@ func NewServer(connectionString string, opts ...ServerOption) (*Server, error) {
 	if err := db.Ping(); err != nil {
 		return nil, errors.Wrap(err, "ping")
 	}
+	if s.hostname, err = os.Hostname(); err != nil {
+		return nil, errors.Wrap(err, "hostname")
+	}
+	if err := db.QueryRow(sqlQueryIdentity).Scan(&s.database, &s.databaseHost); err != nil {
+		return nil, errors.Wrap(err, "query identity")
+	}
+	if s.databaseHost == "" {
+		// connected over a unix socket
+		s.databaseHost = s.hostname
+	}
 	s.l = pq.NewListener(connectionString, minReconnectInterval, maxReconnectInterval, func(ev pq.ListenerEventType, err error) {
 		s.logger.WithField("listener-event", ev).Debugln("got listener event")
+		listenerEvents.WithLabelValues(listenerEventNames[ev]).Inc()
+		s.health.listenerEvent(ev)
 		if err != nil {
 			s.logger.WithField("listener-event", ev).WithError(err).Errorln("got listener event error")
 		}
@@ -104,7 +190,7 @@ func NewServer(connectionString string, opts ...ServerOption) (*Server, error) {
 	if err := s.l.Listen(channel); err != nil {
 		return nil, errors.Wrap(err, "listen")
 	}
-	if err := s.l.Listen(channel + "-ctl"); err != nil {
+	if err := s.l.Listen(controlChannel); err != nil {
 		return nil, errors.Wrap(err, "listen")
 	}
 	s.db = db
@@ -125,53 +211,186 @@ func (s *Server) Close() error {
 }
 
 // InstallTriggers sets up triggers to start observing changes for the set of tables in the database.
+// Tables created later are set up while HandleEvents is running.
+//
+// If the server is configured for logical replication the publication and replication slot are set up instead.
 func (s *Server) InstallTriggers() error {
-	_, err := s.db.Exec(sqlTriggerFunction)
+	tableNames, err := s.tableNames()
 	if err != nil {
 		return err
 	}
-	// TODO(tmc): watch for new tables
-	tableNames, err := s.tableNames()
-	if err != nil {
+	if len(tableNames) == 0 {
+		return errors.New("no tables found")
+	}
+	if err := s.installTriggers(tableNames); err != nil {
 		return err
 	}
+	if s.durable() {
+		if err := s.loadPosition(); err != nil {
+			return err
+		}
+	}
+	s.installTableWatch()
+	s.tables = make(map[table]bool, len(tableNames))
+	for _, t := range tableNames {
+		s.tables[t] = true
+	}
+	s.health.update(func(h *healthStatus) { h.installed = true })
+	return nil
+}
+
+func (s *Server) installTriggers(tableNames []table) error {
+	if s.slot != "" {
+		return s.installReplication(tableNames)
+	}
+	if s.outbox {
+		if _, err := s.db.Exec(sqlCreateOutbox); err != nil {
+			return errors.Wrap(err, "create outbox")
+		}
+	}
+	if s.payloadTable {
+		if _, err := s.db.Exec(sqlCreatePayloadTable); err != nil {
+			return errors.Wrap(err, "create payload table")
+		}
+	}
+	functions := []*template.Template{sqlTriggerFunction}
+	if s.commitTrigger {
+		functions = append(functions, sqlCommitFunction)
+	}
+	for _, tmpl := range functions {
+		fn := &bytes.Buffer{}
+		if err := tmpl.Execute(fn, s.triggerFunctionOptions()); err != nil {
+			return errors.Wrap(err, "trigger function template")
+		}
+		if _, err := s.db.Exec(fn.String()); err != nil {
+			return err
+		}
+	}
+	if s.commitTrigger {
+		// truncations are recorded in pqstream_commits, as the commit trigger only fires for changed rows.
+		for _, q := range []string{sqlCreateCommits, sqlRemoveCommitsTrigger, sqlInstallCommitsTrigger} {
+			if _, err := s.db.Exec(q); err != nil {
+				return errors.Wrap(err, "create commits table")
+			}
+		}
+	}
 	for _, t := range tableNames {
 		if err := s.installTrigger(t); err != nil {
 			return errors.Wrap(err, fmt.Sprintf("installTrigger table %s", t))
 		}
 	}
-	if len(tableNames) == 0 {
-		return errors.New("no tables found")
-	}
 	return nil
 }
 
-func (s *Server) tableNames() ([]string, error) {
+// table identifies a table within a schema.
+type table struct {
+	schema, name string
+}
+
+func (t table) String() string {
+	return t.schema + "." + t.name
+}
+
+// quoted returns the schema qualified, quoted identifier of the table for use in queries.
+func (t table) quoted() string {
+	return pq.QuoteIdentifier(t.schema) + "." + pq.QuoteIdentifier(t.name)
+}
+
+func (s *Server) tableNames() ([]table, error) {
 	rows, err := s.db.Query(sqlQueryTables)
 	if err != nil {
 		return nil, err
 	}
-	var tableNames []string
+	defer rows.Close()
+	var tableNames []table
 	for rows.Next() {
-		var t string
-		if err := rows.Scan(&t); err != nil {
+		var t table
+		if err := rows.Scan(&t.schema, &t.name); err != nil {
 			return nil, errors.Wrap(err, fmt.Sprintln("tableNames scan, after", len(tableNames)))
 		}
-		if s.tableRe != nil && !s.tableRe.MatchString(t) {
+		if !s.managesSchema(t.schema) {
+			continue
+		}
+		if s.tableRe != nil && !s.tableRe.MatchString(t.name) {
 			continue
 		}
 		tableNames = append(tableNames, t)
 	}
-	return tableNames, nil
+	return tableNames, rows.Err()
 }
 
-func (s *Server) installTrigger(table string) error {
-	q := fmt.Sprintf(sqlInstallTrigger, table)
-	_, err := s.db.Exec(q)
-	return err
+func (s *Server) managesSchema(schema string) bool {
+	if s.schemaRe == nil {
+		return schema == "public"
+	}
+	return s.schemaRe.MatchString(schema)
 }
 
-// RemoveTriggers removes triggers from the database.
+// triggerFunctionOptions controls how sqlTriggerFunction is rendered.
+type triggerFunctionOptions struct {
+	// Outbox records changes in the outbox table instead of sending them as notifications.
+	Outbox bool
+	// PayloadTable stores changes too large for a notification in the payload table.
+	PayloadTable bool
+	// Commit records truncations in the commits table so the commit trigger reports their transactions.
+	Commit bool
+	// Function is the name of the row trigger function.
+	Function string
+	// Exclude are columns left out of notifications.
+	Exclude []string
+}
+
+func (s *Server) triggerFunctionOptions() triggerFunctionOptions {
+	return triggerFunctionOptions{
+		Outbox:       s.outbox,
+		PayloadTable: s.payloadTable,
+		Commit:       s.commitTrigger,
+		Function:     "pqstream_notify",
+	}
+}
+
+// Row returns the expression of record, i.e. NEW, as json without the excluded columns.
+func (o triggerFunctionOptions) Row(record string) string {
+	if len(o.Exclude) == 0 {
+		return fmt.Sprintf("row_to_json(%s)", record)
+	}
+	return fmt.Sprintf("(SELECT coalesce(json_object_agg(key, value), '{}') FROM json_each(row_to_json(%s)) WHERE key <> ALL (ARRAY[%s]))",
+		record, triggerArguments(o.Exclude))
+}
+
+// installTrigger (re)creates the row and truncate triggers on t, and the commit trigger if enabled, passing its
+// primary key columns to the row trigger.
+func (s *Server) installTrigger(t table) error {
+	if err := s.removeTrigger(t); err != nil {
+		return err
+	}
+	key, err := primaryKey(s.db, t)
+	if err != nil {
+		return err
+	}
+	function := "pqstream_notify"
+	if s.triggerRedactions {
+		function = tableFunction(t)
+		if err := s.installTableFunction(t, s.fieldRedactions()); err != nil {
+			return err
+		}
+	}
+	if _, err := s.db.Exec(fmt.Sprintf(sqlInstallTrigger, t.quoted(), function, triggerArguments(key))); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(fmt.Sprintf(sqlInstallTruncateTrigger, t.quoted())); err != nil {
+		return err
+	}
+	if s.commitTrigger {
+		if _, err := s.db.Exec(fmt.Sprintf(sqlInstallCommitTrigger, t.quoted())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveTriggers removes triggers from the database, along with the outbox, position, payload and commits tables if
+// they are in use.
 func (s *Server) RemoveTriggers() error {
 	tableNames, err := s.tableNames()
 	if err != nil {
@@ -182,18 +401,55 @@ func (s *Server) RemoveTriggers() error {
 			return errors.Wrap(err, fmt.Sprintf("removeTrigger table:%s", t))
 		}
 	}
+	s.health.update(func(h *healthStatus) { h.installed = false })
+	if _, err := s.db.Exec(sqlRemoveDDLTrigger); err != nil {
+		return errors.Wrap(err, "remove ddl event trigger")
+	}
+	if _, err := s.db.Exec(sqlRemoveDDLFunction); err != nil {
+		return errors.Wrap(err, "remove ddl event trigger function")
+	}
+	if s.outbox {
+		if _, err := s.db.Exec(sqlDropOutbox); err != nil {
+			return errors.Wrap(err, "drop outbox")
+		}
+		if _, err := s.db.Exec(sqlDropPosition); err != nil {
+			return errors.Wrap(err, "drop position")
+		}
+	}
+	if s.payloadTable {
+		if _, err := s.db.Exec(sqlDropPayloadTable); err != nil {
+			return errors.Wrap(err, "drop payload table")
+		}
+	}
+	if s.commitTrigger {
+		if _, err := s.db.Exec(sqlDropCommits); err != nil {
+			return errors.Wrap(err, "drop commits table")
+		}
+	}
 	return nil
 }
 
-func (s *Server) removeTrigger(table string) error {
-	q := fmt.Sprintf(sqlRemoveTrigger, table)
-	_, err := s.db.Exec(q)
-	return err
+func (s *Server) removeTrigger(t table) error {
+	for _, q := range []string{sqlRemoveTrigger, sqlRemoveTruncateTrigger, sqlRemoveCommitTrigger} {
+		if _, err := s.db.Exec(fmt.Sprintf(q, t.quoted())); err != nil {
+			return err
+		}
+	}
+	if _, err := s.db.Exec(fmt.Sprintf(sqlRemoveTableFunction, tableFunction(t))); err != nil {
+		return err
+	}
+	return nil
 }
 
 // fallbackLookup will be invoked if we have apparently exceeded the 8000 byte notify limit.
-func (s *Server) fallbackLookup(e *pqs.Event) error {
-	rows, err := s.db.Query(fmt.Sprintf(sqlFetchRowByID, e.Table, fallbackIDColumnType), e.Id)
+// The row is fetched by key, its primary key or its id column if the table has none.
+func (s *Server) fallbackLookup(e *pqs.Event, key *ptypes_struct.Struct) error {
+	keyJSON, err := (&jsonpb.Marshaler{}).MarshalToString(key)
+	if err != nil {
+		return errors.Wrap(err, "fallback key")
+	}
+	t := table{schema: e.Schema, name: e.Table}
+	rows, err := s.db.Query(fetchRowByKeyQuery(t, key), keyJSON)
 	if err != nil {
 		return errors.Wrap(err, "fallback query")
 	}
@@ -220,61 +476,234 @@ func (s *Server) handleEvent(subscribers map[*subscription]bool, ev *pq.Notifica
 	if err := jsonpb.UnmarshalString(ev.Extra, re); err != nil {
 		return errors.Wrap(err, "jsonpb unmarshal")
 	}
+	if re.Token != 0 {
+		// without the stored payload the event is sent as is and the row is looked up instead.
+		if err := s.takePayload(re); err != nil {
+			s.logger.WithField("event", re).WithError(err).Errorln("reading stored payload failed")
+		}
+	}
+	s.handleRawEvent(subscribers, re)
+	return nil
+}
+
+// newEvent returns the event sent to clients for re.
+func newEvent(re *pqs.RawEvent) *pqs.Event {
+	return &pqs.Event{
+		Schema:         re.Schema,
+		Table:          re.Table,
+		Op:             re.Op,
+		Id:             re.Id,
+		Payload:        re.Payload,
+		Previous:       re.Previous,
+		Txid:           re.Txid,
+		UnknownColumns: re.UnknownColumns,
+		Key:            re.Key,
+		Sequence:       re.Sequence,
+		CommitTime:     re.CommitTime,
+		ChangeTime:     re.ChangeTime,
+	}
+}
+
+// handleRawEvent prepares an event from either source and copies it to subscribers.
+func (s *Server) handleRawEvent(subscribers map[*subscription]bool, re *pqs.RawEvent) {
+	switch re.Op {
+	case pqs.Operation_BEGIN:
+		s.beginTransaction(subscribers, re)
+		return
+	case pqs.Operation_COMMIT:
+		s.finishTransaction(subscribers, re)
+		return
+	}
+
+	// the row is looked up by its key as changed, before redactions.
+	key := lookupKey(newEvent(re))
 
 	// perform field redactions
 	s.redactFields(re)
 
-	e := &pqs.Event{
-		Schema:  re.Schema,
-		Table:   re.Table,
-		Op:      re.Op,
-		Id:      re.Id,
-		Payload: re.Payload,
-	}
+	e := newEvent(re)
+	e.ReceiveTime = ptypes.TimestampNow()
+	eventsReceived.With(eventLabels(e)).Inc()
 
-	if re.Op == pqs.Operation_UPDATE {
+	// without the previous row, i.e. if it was too large to be notified, the changes are not known.
+	if re.Op == pqs.Operation_UPDATE && re.Previous != nil {
 		if patch, err := generatePatch(re.Payload, re.Previous); err != nil {
+			patchErrors.WithLabelValues(e.Schema, e.Table).Inc()
 			s.logger.WithField("event", e).WithError(err).Infoln("issue generating json patch")
 		} else {
 			e.Changes = patch
 		}
 	}
 
-	if e.Payload == nil && e.Id != "" {
-		if err := s.fallbackLookup(e); err != nil {
+	if e.Payload == nil && key != nil {
+		result := "ok"
+		if err := s.fallbackLookup(e, key); err != nil {
+			result = "error"
 			s.logger.WithField("event", e).WithError(err).Errorln("fallback lookup failed")
 		}
+		fallbackLookups.WithLabelValues(e.Schema, e.Table, result).Inc()
+		// the row read back is redacted like the notified ones.
+		redactor{key: s.redactionKey}.redact(e.Payload, s.fieldRedactions().fields(e.Schema, e.Table))
+	}
+	s.addToTransaction(subscribers, e)
+}
 
+// dispatch assigns the next position to e, retains it for resuming subscribers and copies it to subscribers.
+// If the position is stored in the database, e is only copied to subscribers by the next flush.
+func (s *Server) dispatch(subscribers map[*subscription]bool, e *pqs.Event) {
+	s.identify(e)
+	s.position++
+	e.Position = s.position
+	e.Epoch = s.epoch
+	s.replay.add(e)
+	if s.durable() {
+		s.unsent = append(s.unsent, e)
+		return
 	}
+	s.send(subscribers, e)
+}
+
+// send copies e to subscribers, dropping those that can no longer receive events.
+func (s *Server) send(subscribers map[*subscription]bool, e *pqs.Event) {
 	for s := range subscribers {
 		if !s.fn(e) {
 			delete(subscribers, s)
 		}
 	}
-	return nil
+}
+
+// identify adds the identity of the database and server to e, and the receive time if it has none yet.
+func (s *Server) identify(e *pqs.Event) {
+	if e.ReceiveTime == nil {
+		e.ReceiveTime = ptypes.TimestampNow()
+	}
+	e.Database = s.database
+	e.DatabaseHost = s.databaseHost
+	e.Server = s.hostname
+}
+
+// addSubscriber replays any retained events a resuming subscriber missed and then starts delivering live events to it.
+func (s *Server) addSubscriber(subscribers map[*subscription]bool, sub *subscription) {
+	if sub.resumeFrom > 0 {
+		if sub.resumeEpoch != s.epoch {
+			sub.errc <- status.Errorf(codes.OutOfRange, "resume epoch %q is not the current epoch %q, the stream has restarted", sub.resumeEpoch, s.epoch)
+			return
+		}
+		events, err := s.replay.since(sub.resumeFrom)
+		if err != nil {
+			sub.errc <- err
+			return
+		}
+		for _, e := range events {
+			if !sub.fn(e) {
+				return
+			}
+		}
+	}
+	subscribers[sub] = true
 }
 
 // HandleEvents processes events from the database and copies them to relevant clients.
 func (s *Server) HandleEvents(ctx context.Context) error {
 	subscribers := map[*subscription]bool{}
 	events := s.l.NotificationChannel()
+	s.health.update(func(h *healthStatus) { h.handling = true })
+	defer s.health.update(func(h *healthStatus) { h.handling = false })
+	var changes chan replicationChange
+	errc := make(chan error, 1)
+	if s.slot != "" {
+		changes = make(chan replicationChange)
+		go func() {
+			errc <- s.consumeReplicationSlot(ctx, changes)
+		}()
+	}
+	var reconcile <-chan time.Time
+	if s.reconcileInterval > 0 {
+		t := time.NewTicker(s.reconcileInterval)
+		defer t.Stop()
+		reconcile = t.C
+	}
+	var expire <-chan time.Time
+	if s.payloadTable {
+		t := time.NewTicker(payloadExpiryInterval)
+		defer t.Stop()
+		expire = t.C
+	}
 	for {
+		// the slot retains changes while no subscriber is connected.
+		received := changes
+		if len(subscribers) == 0 {
+			received = nil
+		}
 		select {
 		case <-ctx.Done():
 			return nil
+		case err := <-errc:
+			return errors.Wrap(err, "replication")
 		case sub := <-s.subscribe:
 			s.logger.Debugln("got subscriber")
-			subscribers[sub] = true
+			s.addSubscriber(subscribers, sub)
+			if s.outbox {
+				// deliver anything recorded while no subscriber was connected.
+				if err := s.drainOutbox(subscribers); err != nil {
+					return err
+				}
+			}
 		case ev := <-events:
 			// TODO(tmc): separate case handling into method
 			s.logger.WithField("event", ev).Debugln("got event")
+			if ev != nil && ev.Channel == controlChannel {
+				if err := s.handleControl(ev); err != nil {
+					return err
+				}
+				continue
+			}
+			if s.outbox {
+				// notifications only signal new outbox entries, a nil notification means some may have been missed.
+				if err := s.drainOutbox(subscribers); err != nil {
+					return err
+				}
+				continue
+			}
 			if err := s.handleEvent(subscribers, ev); err != nil {
 				return err
 			}
+		case <-reconcile:
+			if err := s.reconcileTables(); err != nil {
+				return err
+			}
+		case <-expire:
+			if err := s.expirePayloads(); err != nil {
+				s.logger.WithError(err).Errorln("expiring stored payloads failed")
+			}
+		case c := <-received:
+			if c.done != nil {
+				err := s.flush(subscribers, s.savePosition)
+				c.done <- err
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			s.logger.WithField("event", c.event).Debugln("got change")
+			s.handleRawEvent(subscribers, c.event)
 		case <-time.After(s.listenerPingInterval):
 			s.logger.WithField("interval", s.listenerPingInterval).Debugln("pinging")
-			if err := s.l.Ping(); err != nil {
-				return errors.Wrap(err, "Ping")
+			// nothing arrived for a while, so the transaction being received is complete.
+			s.finishTransaction(subscribers, nil)
+			if err := s.flush(subscribers, s.savePosition); err != nil {
+				return err
+			}
+			// the listener reconnects by itself, meanwhile the server is reported unhealthy.
+			err := s.l.Ping()
+			if err != nil {
+				s.logger.WithError(err).Errorln("listener ping failed")
+			}
+			s.health.update(func(h *healthStatus) { h.pingErr = err })
+			if s.outbox {
+				if err := s.drainOutbox(subscribers); err != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -284,21 +713,41 @@ func (s *Server) HandleEvents(ctx context.Context) error {
 func (s *Server) Listen(r *pqs.ListenRequest, srv pqs.PQStream_ListenServer) error {
 	ctx := srv.Context()
 	s.logger.WithField("listen-request", r).Infoln("got listen request")
-	tableRe, err := regexp.Compile(r.TableRegexp)
+	match, requested, err := eventFilter(r)
 	if err != nil {
 		return err
 	}
-	events := make(chan *pqs.Event) // TODO(tmc): will likely buffer after benchmarking
-	s.subscribe <- &subscription{fn: func(e *pqs.Event) bool {
-		if !tableRe.MatchString(e.Table) {
-			return true
-		}
-		select {
-		case <-ctx.Done():
+	allowed, err := s.authorize(ctx, requested)
+	if err != nil {
+		return err
+	}
+	match = restrictFilter(match, allowed)
+	redactions, err := s.subscriberRedactions(ctx, r)
+	if err != nil {
+		return err
+	}
+	redact := s.eventRedaction(redactions)
+	project := eventProjection(r)
+	format, err := changeFormatter(r)
+	if err != nil {
+		return err
+	}
+	boundaries := newBoundaryFilter(r, match)
+	queue := newEventQueue(s.queueSize, s.overflowPolicy)
+	principal := principalName(ctx)
+	subscriberCount.Inc()
+	defer subscriberCount.Dec()
+	errc := make(chan error, 1)
+	s.subscribe <- &subscription{resumeFrom: r.ResumeFrom, resumeEpoch: r.ResumeEpoch, errc: errc, fn: func(e *pqs.Event) bool {
+		if ctx.Err() != nil {
 			return false
-		case events <- e:
-			return true
 		}
+		for _, e := range boundaries.filter(redact(e)) {
+			if !queue.push(ctx.Done(), e) {
+				return false
+			}
+		}
+		return true
 	}}
 	for {
 		select {
@@ -306,10 +755,54 @@ func (s *Server) Listen(r *pqs.ListenRequest, srv pqs.PQStream_ListenServer) err
 			return nil
 		case <-ctx.Done():
 			return nil
-		case e := <-events:
-			if err := srv.Send(e); err != nil {
+		case err := <-errc:
+			return err
+		case <-queue.ready:
+			for _, e := range queue.pop() {
+				if err := srv.Send(format(project(e))); err != nil {
+					return err
+				}
+				eventsSent.With(sentLabels(e, principal)).Inc()
+			}
+			if err := overflowError(queue); err != nil {
+				s.logger.WithField("listen-request", r).WithError(err).Warnln("disconnecting slow subscriber")
 				return err
 			}
 		}
 	}
 }
+
+// eventFilter returns a function reporting whether an event matches the request, and one reporting
+// whether a table is requested. Invalid requests result in an InvalidArgument error.
+func eventFilter(r *pqs.ListenRequest) (func(*pqs.Event) bool, func(table) bool, error) {
+	tableRe, err := regexp.Compile(r.TableRegexp)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "table_regexp: %v", err)
+	}
+	schemaRe, err := regexp.Compile(r.SchemaRegexp)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "schema_regexp: %v", err)
+	}
+	var filter filterExpr
+	if r.Filter != "" {
+		if filter, err = compileFilter(r.Filter); err != nil {
+			return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	requested := func(t table) bool {
+		return tableRe.MatchString(t.name) && schemaRe.MatchString(t.schema)
+	}
+	ops := make(map[pqs.Operation]bool, len(r.Ops))
+	for _, op := range r.Ops {
+		ops[op] = true
+	}
+	return func(e *pqs.Event) bool {
+		if len(ops) > 0 && !ops[e.Op] {
+			return false
+		}
+		if !requested(table{schema: e.Schema, name: e.Table}) {
+			return false
+		}
+		return filter == nil || truthy(filter.eval(e))
+	}, requested, nil
+}
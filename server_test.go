@@ -13,10 +13,16 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/tmc/pqstream/pqs"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	ptypes_struct "github.com/golang/protobuf/ptypes/struct"
 )
 
 var testConnectionString = "postgres://localhost?sslmode=disable"
@@ -28,6 +34,7 @@ var (
 	testInsertTemplate = `insert into notes values (default, default, '%s')`
 	testUpdate         = `update notes set note = 'here is an updated note' where id=1`
 	testUpdateTemplate = `update notes set note = 'i%s' where id=1`
+	testTruncate       = `truncate notes`
 )
 
 func init() {
@@ -80,6 +87,10 @@ func TestNewServer(t *testing.T) {
 		{"good", args{
 			connectionString: testConnectionString,
 		}, nil, false},
+		{"negative_queue_size", args{
+			connectionString: testConnectionString,
+			opts:             []ServerOption{WithSubscriberQueue(-1, OverflowBlock)},
+		}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -161,6 +172,17 @@ func loggerFromT(t *testing.T) *logrus.Logger {
 	return logger
 }
 
+// applyShared returns fn(e), failing the test if fn modifies e, as events are shared between subscribers.
+func applyShared(t *testing.T, fn func(*pqs.Event) *pqs.Event, e *pqs.Event) *pqs.Event {
+	t.Helper()
+	original := proto.Clone(e)
+	got := fn(e)
+	if !proto.Equal(e, original) {
+		t.Errorf("modified the shared event to %v", e)
+	}
+	return got
+}
+
 func TestServer_HandleEvents(t *testing.T) {
 	db := dbOrSkip(t)
 	type testCase struct {
@@ -184,6 +206,14 @@ func TestServer_HandleEvents(t *testing.T) {
 				t.Fatal(err)
 			}
 		}, false},
+		{"basic_truncate", func(t *testing.T, s *Server) {
+			if _, err := s.db.Exec(testInsert); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.db.Exec(testTruncate); err != nil {
+				t.Fatal(err)
+			}
+		}, false},
 	}
 
 	mkTestCase := func(n int, alsoUpdate bool) testCase {
@@ -404,3 +434,103 @@ func TestServer_Triggers(t *testing.T) {
 		})
 	}
 }
+
+func Test_table_quoted(t *testing.T) {
+	tests := []struct {
+		t    table
+		want string
+	}{
+		{table{"public", "notes"}, `"public"."notes"`},
+		{table{"Sales", "order items"}, `"Sales"."order items"`},
+		{table{"odd\"schema", "t"}, `"odd""schema"."t"`},
+	}
+	for _, tt := range tests {
+		if got := tt.t.quoted(); got != tt.want {
+			t.Errorf("table(%v).quoted() = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+}
+
+func Test_eventFilter(t *testing.T) {
+	insert := &pqs.Event{Schema: "public", Table: "notes", Op: pqs.Operation_INSERT}
+	del := &pqs.Event{Schema: "audit", Table: "notes", Op: pqs.Operation_DELETE}
+	tests := []struct {
+		name    string
+		r       *pqs.ListenRequest
+		want    []bool
+		wantErr bool
+	}{
+		{"all", &pqs.ListenRequest{}, []bool{true, true}, false},
+		{"bad_table_regexp", &pqs.ListenRequest{TableRegexp: "("}, nil, true},
+		{"bad_schema_regexp", &pqs.ListenRequest{SchemaRegexp: "("}, nil, true},
+		{"bad_filter", &pqs.ListenRequest{Filter: "payload.id =="}, nil, true},
+		{"filter", &pqs.ListenRequest{Filter: `op == "DELETE"`}, []bool{false, true}, false},
+		{"table", &pqs.ListenRequest{TableRegexp: "users"}, []bool{false, false}, false},
+		{"schema", &pqs.ListenRequest{SchemaRegexp: "^audit$"}, []bool{false, true}, false},
+		{"ops", &pqs.ListenRequest{Ops: []pqs.Operation{pqs.Operation_DELETE}}, []bool{false, true}, false},
+		{"ops_and_schema", &pqs.ListenRequest{
+			Ops:          []pqs.Operation{pqs.Operation_INSERT, pqs.Operation_DELETE},
+			SchemaRegexp: "^public$",
+		}, []bool{true, false}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, _, err := eventFilter(tt.r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("eventFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if status.Code(err) != codes.InvalidArgument {
+					t.Errorf("eventFilter() error = %v, want code %v", err, codes.InvalidArgument)
+				}
+				return
+			}
+			for i, e := range []*pqs.Event{insert, del} {
+				if got := match(e); got != tt.want[i] {
+					t.Errorf("eventFilter()(%v) = %v, want %v", e, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestServer_handleRawEvent_changes(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		want     string
+	}{
+		{"previous", `{"id":1,"note":"a"}`, `{"note":"a"}`},
+		{"unknown_previous", ``, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re := &pqs.RawEvent{Schema: "public", Table: "notes", Op: pqs.Operation_UPDATE}
+			re.Payload = &ptypes_struct.Struct{}
+			if err := jsonpb.UnmarshalString(`{"id":1,"note":"b"}`, re.Payload); err != nil {
+				t.Fatal(err)
+			}
+			if tt.previous != "" {
+				re.Previous = &ptypes_struct.Struct{}
+				if err := jsonpb.UnmarshalString(tt.previous, re.Previous); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s := &Server{replay: newReplayBuffer(16), redactions: make(Redactions)}
+			var got *pqs.Event
+			s.handleRawEvent(map[*subscription]bool{
+				{fn: func(e *pqs.Event) bool {
+					got = e
+					return true
+				}}: true,
+			}, re)
+			changes := ""
+			if got.Changes != nil {
+				changes, _ = (&jsonpb.Marshaler{}).MarshalToString(got.Changes)
+			}
+			if changes != tt.want {
+				t.Errorf("handleRawEvent() changes = %q, want %q", changes, tt.want)
+			}
+		})
+	}
+}
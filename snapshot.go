@@ -0,0 +1,228 @@
+package pqstream
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/pkg/errors"
+	"github.com/tmc/pqstream/pqs"
+
+	ptypes_struct "github.com/golang/protobuf/ptypes/struct"
+)
+
+// ListenWithSnapshot streams the current contents of the matching tables followed by live events.
+//
+// Live events are queued from before the snapshot is taken and events from transactions already visible
+// in the snapshot are dropped, so the switch to live events has neither gaps nor duplicates.
+func (s *Server) ListenWithSnapshot(r *pqs.ListenRequest, srv pqs.PQStream_ListenWithSnapshotServer) error {
+	ctx := srv.Context()
+	s.logger.WithField("listen-request", r).Infoln("got listen with snapshot request")
+	match, requested, err := eventFilter(r)
+	if err != nil {
+		return err
+	}
+	allowed, err := s.authorize(ctx, requested)
+	if err != nil {
+		return err
+	}
+	match = restrictFilter(match, allowed)
+	redactions, err := s.subscriberRedactions(ctx, r)
+	if err != nil {
+		return err
+	}
+	redact := s.eventRedaction(redactions)
+	project := eventProjection(r)
+	format, err := changeFormatter(r)
+	if err != nil {
+		return err
+	}
+	boundaries := newBoundaryFilter(r, match)
+	// live events are queued without limit while the snapshot is read.
+	queue := newEventQueue(0, s.overflowPolicy)
+	principal := principalName(ctx)
+	subscriberCount.Inc()
+	defer subscriberCount.Dec()
+	errc := make(chan error, 1)
+	s.subscribe <- &subscription{errc: errc, fn: func(e *pqs.Event) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		for _, e := range boundaries.filter(redact(e)) {
+			if !queue.push(ctx.Done(), e) {
+				return false
+			}
+		}
+		return true
+	}}
+	include := func(t table) bool {
+		return requested(t) && allowed(t)
+	}
+	snapshot, err := s.readSnapshot(ctx, include, func(e *pqs.Event) error {
+		e = redact(e)
+		if !match(e) {
+			return nil
+		}
+		if err := srv.Send(format(project(e))); err != nil {
+			return err
+		}
+		eventsSent.With(sentLabels(e, principal)).Inc()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	queue.setLimit(s.queueSize)
+	for {
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case <-ctx.Done():
+			return nil
+		case err := <-errc:
+			return err
+		case <-queue.ready:
+			for _, e := range queue.pop() {
+				if snapshot.visible(e.Txid) {
+					continue
+				}
+				if err := srv.Send(format(project(e))); err != nil {
+					return err
+				}
+				eventsSent.With(sentLabels(e, principal)).Inc()
+			}
+			if err := overflowError(queue); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readSnapshot reads every row of the managed tables selected by include within a single transaction and
+// passes them to fn as SNAPSHOT events. It returns the transaction snapshot the rows were read in.
+func (s *Server) readSnapshot(ctx context.Context, include func(table) bool, fn func(*pqs.Event) error) (*txidSnapshot, error) {
+	tableNames, err := s.tableNames()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "begin snapshot")
+	}
+	defer tx.Rollback()
+	// the first query of a repeatable read transaction determines its snapshot.
+	var txids string
+	if err := tx.QueryRowContext(ctx, sqlQuerySnapshot).Scan(&txids); err != nil {
+		return nil, errors.Wrap(err, "query snapshot")
+	}
+	snapshot, err := parseTxidSnapshot(txids)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tableNames {
+		if !include(t) {
+			continue
+		}
+		if err := s.readSnapshotTable(ctx, tx, t, fn); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("snapshot table %s", t))
+		}
+	}
+	return snapshot, nil
+}
+
+func (s *Server) readSnapshotTable(ctx context.Context, tx *sql.Tx, t table, fn func(*pqs.Event) error) error {
+	key, err := primaryKey(tx, t)
+	if err != nil {
+		return err
+	}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(sqlSnapshotTable, t.quoted()))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var payload string
+		if err := rows.Scan(&payload); err != nil {
+			return err
+		}
+		re := &pqs.RawEvent{
+			Schema:  t.schema,
+			Table:   t.name,
+			Op:      pqs.Operation_SNAPSHOT,
+			Payload: &ptypes_struct.Struct{},
+		}
+		if err := jsonpb.UnmarshalString(payload, re.Payload); err != nil {
+			return errors.Wrap(err, "unmarshal")
+		}
+		if id, ok := re.Payload.Fields["id"]; ok {
+			re.Id = valueText(id)
+		}
+		re.Key = rowKey(re.Payload, key)
+		s.redactFields(re)
+		e := newEvent(re)
+		s.identify(e)
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// txidSnapshot describes which transactions are visible to a snapshot, see txid_current_snapshot().
+type txidSnapshot struct {
+	xmin, xmax int64
+	xip        map[int64]bool
+}
+
+// parseTxidSnapshot parses the text representation of a snapshot ("xmin:xmax:xip,...").
+func parseTxidSnapshot(s string) (*txidSnapshot, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid snapshot %q", s)
+	}
+	snapshot := &txidSnapshot{xip: make(map[int64]bool)}
+	var err error
+	if snapshot.xmin, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return nil, errors.Wrap(err, "snapshot xmin")
+	}
+	if snapshot.xmax, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return nil, errors.Wrap(err, "snapshot xmax")
+	}
+	for _, p := range strings.Split(parts[2], ",") {
+		if p == "" {
+			continue
+		}
+		txid, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "snapshot xip")
+		}
+		snapshot.xip[txid] = true
+	}
+	return snapshot, nil
+}
+
+// visible reports whether the changes made by txid are visible in the snapshot.
+//
+// Transaction ids without an epoch (as sent by logical replication) are assumed to be from the snapshot's epoch.
+// A zero txid is never visible so events that lack one are always delivered.
+func (t *txidSnapshot) visible(txid int64) bool {
+	if txid == 0 {
+		return false
+	}
+	if txid < 1<<32 && t.xmax >= 1<<32 {
+		// pick the epoch that places txid closest to the snapshot.
+		txid |= t.xmax &^ (1<<32 - 1)
+		if txid > t.xmax+1<<31 {
+			txid -= 1 << 32
+		} else if txid < t.xmax-1<<31 {
+			txid += 1 << 32
+		}
+	}
+	if txid < t.xmin {
+		return true
+	}
+	return txid < t.xmax && !t.xip[txid]
+}
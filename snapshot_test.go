@@ -0,0 +1,87 @@
+package pqstream
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tmc/pqstream/pqs"
+)
+
+func Test_txidSnapshot_visible(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot string
+		txid     int64
+		want     bool
+		wantErr  bool
+	}{
+		{"invalid", "10:20", 0, false, true},
+		{"invalid_xip", "10:20:x", 0, false, true},
+		{"zero", "10:20:", 0, false, false},
+		{"before_xmin", "10:20:", 9, true, false},
+		{"between", "10:20:", 15, true, false},
+		{"in_progress", "10:20:12,15", 15, false, false},
+		{"xmax", "10:20:", 20, false, false},
+		{"after", "10:20:", 25, false, false},
+		{"epoch", "4294967306:4294967316:", 4294967300, true, false},
+		{"epoch_after", "4294967306:4294967316:", 4294967317, false, false},
+		{"epochless_before", "4294967306:4294967316:", 5, true, false},
+		{"epochless_after", "4294967306:4294967316:", 21, false, false},
+		{"epochless_previous_epoch", "4294967306:4294967316:", 4294967290, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseTxidSnapshot(tt.snapshot)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTxidSnapshot(%q) error = %v, wantErr %v", tt.snapshot, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := s.visible(tt.txid); got != tt.want {
+				t.Errorf("txidSnapshot.visible(%v) = %v, want %v", tt.txid, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_readSnapshot(t *testing.T) {
+	db := dbOrSkip(t)
+	cs, cleanup := testDBConn(t, db, "snapshot")
+	defer cleanup()
+	s, err := NewServer(cs, WithLogger(loggerFromT(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	const nInserts = 3
+	for i := 0; i < nInserts; i++ {
+		if _, err := s.db.Exec(testInsert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var txid int64
+	if err := s.db.QueryRow("select txid_current()").Scan(&txid); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []*pqs.Event
+	snapshot, err := s.readSnapshot(context.Background(), func(table) bool { return true }, func(e *pqs.Event) error {
+		got = append(got, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != nInserts {
+		t.Errorf("readSnapshot() read %v events, want %v", len(got), nInserts)
+	}
+	for _, e := range got {
+		if e.Op != pqs.Operation_SNAPSHOT || e.Table != "notes" || e.Id == "" {
+			t.Errorf("readSnapshot() read %v, want a snapshot of a note", e)
+		}
+	}
+	if !snapshot.visible(txid) {
+		t.Errorf("readSnapshot() snapshot does not include earlier transaction %v", txid)
+	}
+}
@@ -0,0 +1,221 @@
+// Package tlsutil builds TLS configurations for pqsd and its clients from certificate files that are read
+// again when they change, so certificates can be rotated without restarts.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ServerConfig returns a configuration serving the certificate in certFile with the key in keyFile.
+// If clientCAFile is set clients must present a certificate signed by one of the CAs in it.
+func ServerConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := newKeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return cert.certificate(), nil
+		},
+	}
+	if clientCAFile == "" {
+		return cfg, nil
+	}
+	cas, err := newCertPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	// clients are verified here rather than with ClientCAs so that the CAs can be reloaded.
+	cfg.ClientAuth = tls.RequireAnyClientCert
+	cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		return verifyPeer(cas.pool(), rawCerts, x509.ExtKeyUsageClientAuth, "")
+	}
+	return cfg, nil
+}
+
+// ClientConfig returns a configuration that verifies servers named serverName with the CAs in caFile, or the
+// system CAs if it is empty, and presents the certificate in certFile with the key in keyFile if they are set.
+func ClientConfig(serverName, caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}
+	if caFile != "" {
+		cas, err := newCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		// servers are verified here rather than with RootCAs so that the CAs can be reloaded.
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyPeer(cas.pool(), rawCerts, x509.ExtKeyUsageServerAuth, serverName)
+		}
+	}
+	if certFile == "" && keyFile == "" {
+		return cfg, nil
+	}
+	cert, err := newKeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return cert.certificate(), nil
+	}
+	return cfg, nil
+}
+
+// ServerName returns the host name of addr to verify the server with, localhost if addr has no host.
+func ServerName(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if host == "" {
+		return "localhost"
+	}
+	return host
+}
+
+// verifyPeer verifies the certificate chain presented by the peer against pool, for usage and, if it is set,
+// for the host name.
+func verifyPeer(pool *x509.CertPool, rawCerts [][]byte, usage x509.ExtKeyUsage, name string) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no peer certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		c, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return errors.Wrap(err, "parse peer certificate")
+		}
+		certs[i] = c
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         pool,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(opts)
+	return errors.Wrap(err, "verify peer certificate")
+}
+
+// keyPair is a certificate and key read from files.
+type keyPair struct {
+	*reloader
+}
+
+func newKeyPair(certFile, keyFile string) (*keyPair, error) {
+	r, err := newReloader(func() (interface{}, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("load key pair %s %s", certFile, keyFile))
+		}
+		return &cert, nil
+	}, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &keyPair{r}, nil
+}
+
+func (k *keyPair) certificate() *tls.Certificate {
+	return k.get().(*tls.Certificate)
+}
+
+// certPool is a set of CA certificates read from a PEM file.
+type certPool struct {
+	*reloader
+}
+
+func newCertPool(file string) (*certPool, error) {
+	r, err := newReloader(func() (interface{}, error) {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "read CA certificates")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("no certificates found in %s", file)
+		}
+		return pool, nil
+	}, file)
+	if err != nil {
+		return nil, err
+	}
+	return &certPool{r}, nil
+}
+
+func (c *certPool) pool() *x509.CertPool {
+	return c.get().(*x509.CertPool)
+}
+
+// reloader holds a value loaded from files and loads it again when any of them has been modified.
+// If loading fails, i.e. because only some of the files have been replaced yet, the previous value
+// is kept and loading is retried on the next use.
+type reloader struct {
+	files []string
+	load  func() (interface{}, error)
+
+	mu       sync.Mutex
+	modTimes []time.Time
+	value    interface{}
+}
+
+func newReloader(load func() (interface{}, error), files ...string) (*reloader, error) {
+	r := &reloader{files: files, load: load}
+	modTimes, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if r.value, err = load(); err != nil {
+		return nil, err
+	}
+	r.modTimes = modTimes
+	return r, nil
+}
+
+func (r *reloader) stat() ([]time.Time, error) {
+	modTimes := make([]time.Time, len(r.files))
+	for i, f := range r.files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, errors.Wrap(err, "stat")
+		}
+		modTimes[i] = fi.ModTime()
+	}
+	return modTimes, nil
+}
+
+// get returns the current value, loading it again if the files have changed since it was loaded.
+func (r *reloader) get() interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	modTimes, err := r.stat()
+	if err != nil || !r.changed(modTimes) {
+		return r.value
+	}
+	if v, err := r.load(); err == nil {
+		r.value, r.modTimes = v, modTimes
+	}
+	return r.value
+}
+
+func (r *reloader) changed(modTimes []time.Time) bool {
+	for i, t := range modTimes {
+		if !t.Equal(r.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and key in PEM form.
+type testCert struct {
+	cert            *x509.Certificate
+	key             *ecdsa.PrivateKey
+	certPEM, keyPEM []byte
+}
+
+func newTestCert(t *testing.T, serial int64, usage x509.ExtKeyUsage, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "pqstream test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// write writes the certificate and key to files in dir, marking them modified at modTime.
+func (c *testCert) write(t *testing.T, dir, name string, modTime time.Time) (certFile, keyFile string) {
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	for f, b := range map[string][]byte{certFile: c.certPEM, keyFile: c.keyPEM} {
+		if err := ioutil.WriteFile(f, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(f, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+// handshake connects a client to a server and returns the serial number of the server certificate.
+func handshake(serverCfg, clientCfg *tls.Config) (*big.Int, error) {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.(*tls.Conn).Handshake()
+		c.Read(make([]byte, 1))
+	}()
+	c, err := tls.Dial("tcp", l.Addr().String(), clientCfg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	// with TLS 1.3 a rejected client certificate is only reported on the first read.
+	c.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := c.Read(make([]byte, 1)); err != nil {
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return nil, err
+		}
+	}
+	return c.ConnectionState().PeerCertificates[0].SerialNumber, nil
+}
+
+func TestServerConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	start := time.Now().Add(-time.Minute)
+
+	ca := newTestCert(t, 1, x509.ExtKeyUsageAny, nil)
+	caFile, _ := ca.write(t, dir, "ca", start)
+	serverCert, serverKey := newTestCert(t, 2, x509.ExtKeyUsageServerAuth, ca).write(t, dir, "server", start)
+	clientCert, clientKey := newTestCert(t, 3, x509.ExtKeyUsageClientAuth, ca).write(t, dir, "client", start)
+
+	serverCfg, err := ServerConfig(serverCert, serverKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCfg, err := ClientConfig("127.0.0.1", caFile, clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := handshake(serverCfg, clientCfg)
+	if err != nil {
+		t.Fatalf("handshake with client certificate: %v", err)
+	}
+	if serial.Int64() != 2 {
+		t.Errorf("server certificate serial = %v, want 2", serial)
+	}
+
+	anonymousCfg, err := ClientConfig("127.0.0.1", caFile, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(serverCfg, anonymousCfg); err == nil {
+		t.Error("handshake without client certificate succeeded, want error")
+	}
+
+	// rotating the server certificate takes effect on the next connection.
+	newTestCert(t, 4, x509.ExtKeyUsageServerAuth, ca).write(t, dir, "server", start.Add(time.Second))
+	if serial, err = handshake(serverCfg, clientCfg); err != nil {
+		t.Fatalf("handshake after rotation: %v", err)
+	}
+	if serial.Int64() != 4 {
+		t.Errorf("server certificate serial after rotation = %v, want 4", serial)
+	}
+
+	// a partially replaced key pair keeps the previous certificate in use.
+	if err := ioutil.WriteFile(serverCert, newTestCert(t, 5, x509.ExtKeyUsageServerAuth, ca).certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if serial, err = handshake(serverCfg, clientCfg); err != nil {
+		t.Fatalf("handshake after partial rotation: %v", err)
+	}
+	if serial.Int64() != 4 {
+		t.Errorf("server certificate serial after partial rotation = %v, want 4", serial)
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	start := time.Now().Add(-time.Minute)
+
+	ca := newTestCert(t, 1, x509.ExtKeyUsageAny, nil)
+	caFile, _ := ca.write(t, dir, "ca", start)
+	serverCert, serverKey := newTestCert(t, 2, x509.ExtKeyUsageServerAuth, ca).write(t, dir, "server", start)
+	serverCfg, err := ServerConfig(serverCert, serverKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCfg, err := ClientConfig("127.0.0.1", caFile, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(serverCfg, clientCfg); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+
+	otherNameCfg, err := ClientConfig("pqsd.example.com", caFile, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(serverCfg, otherNameCfg); err == nil {
+		t.Error("handshake with a server of another name succeeded, want error")
+	}
+
+	// a server certificate of a new CA is rejected until the CA file is rotated.
+	newCA := newTestCert(t, 3, x509.ExtKeyUsageAny, nil)
+	newTestCert(t, 4, x509.ExtKeyUsageServerAuth, newCA).write(t, dir, "server", start.Add(time.Second))
+	if _, err := handshake(serverCfg, clientCfg); err == nil {
+		t.Error("handshake with a server certificate of an unknown CA succeeded, want error")
+	}
+	newCA.write(t, dir, "ca", start.Add(time.Second))
+	serial, err := handshake(serverCfg, clientCfg)
+	if err != nil {
+		t.Fatalf("handshake after CA rotation: %v", err)
+	}
+	if serial.Int64() != 4 {
+		t.Errorf("server certificate serial after CA rotation = %v, want 4", serial)
+	}
+}
+
+func TestServerName(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{":7000", "localhost"},
+		{"pqsd.example.com:7000", "pqsd.example.com"},
+		{"[::1]:7000", "::1"},
+		{"pqsd.example.com", "pqsd.example.com"},
+	}
+	for _, tt := range tests {
+		if got := ServerName(tt.addr); got != tt.want {
+			t.Errorf("ServerName(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestNewKeyPair_missing(t *testing.T) {
+	if _, err := ServerConfig("does-not-exist.crt", "does-not-exist.key", ""); err == nil {
+		t.Error("ServerConfig() with missing files succeeded, want error")
+	}
+	if _, err := ClientConfig("127.0.0.1", "does-not-exist.pem", "", ""); err == nil {
+		t.Error("ClientConfig() with missing CA file succeeded, want error")
+	}
+}
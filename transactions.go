@@ -0,0 +1,181 @@
+package pqstream
+
+import (
+	"github.com/tmc/pqstream/pqs"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+)
+
+// maxPendingTransactionEvents bounds the number of events of a transaction a subscriber holds back until its
+// commit is seen. The events of larger transactions are sent as they arrive and only those held back carry the
+// commit time.
+const maxPendingTransactionEvents = 10000
+
+// WithCommitTrigger installs a deferred constraint trigger on each table that reports when a transaction commits,
+// with its commit time. Without it a transaction is only known to have ended once changes of another transaction
+// arrive or none arrive for a while, which delays the events sent to subscribers that requested transaction
+// boundaries. Postgres queues a deferred call of the trigger for every changed row, so this adds to the cost of
+// every write.
+func WithCommitTrigger() ServerOption {
+	return func(s *Server) {
+		s.commitTrigger = true
+	}
+}
+
+// pendingTransaction is the transaction whose changes are being received.
+//
+// Changes are sent as they arrive, preceded by a BEGIN event, and a COMMIT event follows once the transaction has
+// ended. The end of a transaction is reported by the database with logical replication and with the commit
+// trigger, otherwise it is assumed when changes of another transaction arrive or no more changes arrive.
+type pendingTransaction struct {
+	txid       int64
+	commitTime *timestamp.Timestamp
+	// number of changes received
+	n int64
+	// whether BEGIN has been sent
+	begun bool
+}
+
+// beginTransaction starts a transaction announced by a BEGIN event from the database.
+func (s *Server) beginTransaction(subscribers map[*subscription]bool, re *pqs.RawEvent) {
+	s.finishTransaction(subscribers, nil)
+	s.tx = &pendingTransaction{txid: re.Txid, commitTime: re.CommitTime}
+}
+
+// addToTransaction sends e, preceded by BEGIN if it is the first change of its transaction.
+func (s *Server) addToTransaction(subscribers map[*subscription]bool, e *pqs.Event) {
+	if e.Txid == 0 {
+		s.finishTransaction(subscribers, nil)
+		s.dispatch(subscribers, e)
+		return
+	}
+	if s.tx != nil && s.tx.txid != e.Txid {
+		s.finishTransaction(subscribers, nil)
+	}
+	if s.tx == nil {
+		s.tx = &pendingTransaction{txid: e.Txid}
+	}
+	s.tx.n++
+	if !s.tx.begun {
+		s.tx.begun = true
+		s.dispatch(subscribers, &pqs.Event{
+			Op:         pqs.Operation_BEGIN,
+			Txid:       s.tx.txid,
+			CommitTime: s.tx.commitTime,
+		})
+	}
+	if e.CommitTime == nil {
+		e.CommitTime = s.tx.commitTime
+	}
+	s.dispatch(subscribers, e)
+}
+
+// finishTransaction sends a COMMIT event for the pending transaction if any of its changes were sent. commit is
+// the COMMIT event received from the database, or nil if the transaction ended without one.
+func (s *Server) finishTransaction(subscribers map[*subscription]bool, commit *pqs.RawEvent) {
+	tx := s.tx
+	if tx == nil {
+		return
+	}
+	if commit != nil && commit.Txid != tx.txid {
+		// the commit of a transaction without changes received, the pending one has ended too.
+		commit = nil
+	}
+	s.tx = nil
+	if !tx.begun {
+		return
+	}
+	if commit.GetCommitTime() != nil {
+		tx.commitTime = commit.CommitTime
+	}
+	s.dispatch(subscribers, &pqs.Event{
+		Op:         pqs.Operation_COMMIT,
+		Txid:       tx.txid,
+		Sequence:   tx.n,
+		CommitTime: tx.commitTime,
+	})
+}
+
+// isBoundary reports whether e is a BEGIN or COMMIT event.
+func isBoundary(e *pqs.Event) bool {
+	return e.Op == pqs.Operation_BEGIN || e.Op == pqs.Operation_COMMIT
+}
+
+// boundaryFilter selects the events sent to a subscriber. Transaction boundaries are only sent if requested, and
+// then the matching events of each transaction are held back until it commits and sent between its BEGIN and
+// COMMIT, with the commit time. Transactions without matching events are left out.
+type boundaryFilter struct {
+	transactions bool
+	match        func(*pqs.Event) bool
+
+	begin *pqs.Event
+	held  []*pqs.Event
+	// whether BEGIN has been sent
+	begun bool
+}
+
+func newBoundaryFilter(r *pqs.ListenRequest, match func(*pqs.Event) bool) *boundaryFilter {
+	return &boundaryFilter{transactions: r.Transactions, match: match}
+}
+
+// filter returns the events to send to the subscriber for e.
+func (f *boundaryFilter) filter(e *pqs.Event) []*pqs.Event {
+	switch {
+	case !isBoundary(e):
+		if !f.match(e) {
+			return nil
+		}
+		if !f.transactions || f.begun || f.begin == nil || f.begin.Txid != e.Txid {
+			return []*pqs.Event{e}
+		}
+		f.held = append(f.held, e)
+		if len(f.held) >= maxPendingTransactionEvents {
+			return f.release(nil)
+		}
+		return nil
+	case !f.transactions:
+		return nil
+	case e.Op == pqs.Operation_BEGIN:
+		// the previous transaction has ended without a COMMIT.
+		events := f.release(nil)
+		f.begin, f.begun = e, false
+		return events
+	case f.begin == nil || f.begin.Txid != e.Txid:
+		return nil
+	default:
+		events := f.release(e)
+		if f.begun {
+			events = append(events, e)
+		}
+		f.begin, f.begun = nil, false
+		return events
+	}
+}
+
+// release returns the held events, preceded by BEGIN if it has not been sent yet. If commit is set they are
+// given its commit time.
+func (f *boundaryFilter) release(commit *pqs.Event) []*pqs.Event {
+	if len(f.held) == 0 {
+		return nil
+	}
+	events := make([]*pqs.Event, 0, len(f.held)+2)
+	if !f.begun {
+		f.begun = true
+		events = append(events, withCommitTime(f.begin, commit))
+	}
+	for _, e := range f.held {
+		events = append(events, withCommitTime(e, commit))
+	}
+	f.held = nil
+	return events
+}
+
+// withCommitTime returns e with the commit time of commit if it has none.
+func withCommitTime(e, commit *pqs.Event) *pqs.Event {
+	if e.CommitTime != nil || commit.GetCommitTime() == nil {
+		return e
+	}
+	c := *e
+	c.CommitTime = commit.CommitTime
+	return &c
+}
@@ -0,0 +1,169 @@
+package pqstream
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/tmc/pqstream/pqs"
+)
+
+// eventSummary renders the fields of e relevant to transaction handling.
+func eventSummary(e *pqs.Event) string {
+	s := fmt.Sprintf("%v:%v", e.Op, e.Txid)
+	if e.Sequence != 0 {
+		s += fmt.Sprintf("#%v", e.Sequence)
+	}
+	if e.CommitTime != nil {
+		s += fmt.Sprintf("@%v", e.CommitTime.Seconds)
+	}
+	return s
+}
+
+func TestServer_handleRawEvent_transactions(t *testing.T) {
+	insert := func(txid, seq int64) *pqs.RawEvent {
+		return &pqs.RawEvent{Schema: "public", Table: "notes", Op: pqs.Operation_INSERT, Txid: txid, Sequence: seq}
+	}
+	commit := func(txid int64) *pqs.RawEvent {
+		return &pqs.RawEvent{Op: pqs.Operation_COMMIT, Txid: txid, CommitTime: &timestamp.Timestamp{Seconds: txid * 10}}
+	}
+	tests := []struct {
+		name   string
+		events []*pqs.RawEvent
+		idle   bool
+		want   string
+	}{
+		{"commit", []*pqs.RawEvent{insert(1, 1), insert(1, 2), commit(1)}, false,
+			"BEGIN:1 INSERT:1#1 INSERT:1#2 COMMIT:1#2@10"},
+		{"pending", []*pqs.RawEvent{insert(1, 1)}, false, "BEGIN:1 INSERT:1#1"},
+		{"idle", []*pqs.RawEvent{insert(1, 1)}, true, "BEGIN:1 INSERT:1#1 COMMIT:1#1"},
+		{"next_transaction", []*pqs.RawEvent{insert(1, 1), insert(2, 1), commit(2)}, false,
+			"BEGIN:1 INSERT:1#1 COMMIT:1#1 BEGIN:2 INSERT:2#1 COMMIT:2#1@20"},
+		{"commit_without_changes", []*pqs.RawEvent{commit(1)}, false, ""},
+		{"no_txid", []*pqs.RawEvent{insert(0, 0)}, false, "INSERT:0"},
+		{"replication", []*pqs.RawEvent{
+			{Op: pqs.Operation_BEGIN, Txid: 3, CommitTime: &timestamp.Timestamp{Seconds: 30}},
+			{Op: pqs.Operation_DELETE, Txid: 3, Sequence: 1, CommitTime: &timestamp.Timestamp{Seconds: 30}},
+			{Op: pqs.Operation_COMMIT, Txid: 3, CommitTime: &timestamp.Timestamp{Seconds: 30}},
+		}, false, "BEGIN:3@30 DELETE:3#1@30 COMMIT:3#1@30"},
+		{"replication_without_changes", []*pqs.RawEvent{
+			{Op: pqs.Operation_BEGIN, Txid: 3, CommitTime: &timestamp.Timestamp{Seconds: 30}},
+			{Op: pqs.Operation_COMMIT, Txid: 3, CommitTime: &timestamp.Timestamp{Seconds: 30}},
+		}, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{replay: newReplayBuffer(16), redactions: make(Redactions)}
+			var got []string
+			subscribers := map[*subscription]bool{
+				{fn: func(e *pqs.Event) bool {
+					if e.ReceiveTime == nil {
+						t.Errorf("%v sent without a receive time", eventSummary(e))
+					}
+					got = append(got, eventSummary(e))
+					return true
+				}}: true,
+			}
+			for _, re := range tt.events {
+				s.handleRawEvent(subscribers, re)
+			}
+			if tt.idle {
+				s.finishTransaction(subscribers, nil)
+			}
+			if got := strings.Join(got, " "); got != tt.want {
+				t.Errorf("handleRawEvent() sent %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_boundaryFilter(t *testing.T) {
+	begin := func(txid int64) *pqs.Event { return &pqs.Event{Op: pqs.Operation_BEGIN, Txid: txid} }
+	insert := func(txid int64, table string) *pqs.Event {
+		return &pqs.Event{Op: pqs.Operation_INSERT, Table: table, Txid: txid}
+	}
+	commit := func(txid int64) *pqs.Event {
+		return &pqs.Event{Op: pqs.Operation_COMMIT, Txid: txid, CommitTime: &timestamp.Timestamp{Seconds: txid * 10}}
+	}
+	events := []*pqs.Event{
+		begin(1), insert(1, "users"), insert(1, "notes"), commit(1),
+		begin(2), insert(2, "users"), commit(2),
+	}
+	tests := []struct {
+		name         string
+		events       []*pqs.Event
+		transactions bool
+		want         string
+	}{
+		{"changes_only", events, false, "INSERT:1"},
+		{"transactions", events, true, "BEGIN:1@10 INSERT:1@10 COMMIT:1@10"},
+		{"pending", events[:3], true, ""},
+		{"resumed", events[2:], true, "INSERT:1"},
+		{"without_commit", append(events[:3:3], begin(2), insert(2, "notes")), true, "BEGIN:1 INSERT:1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newBoundaryFilter(&pqs.ListenRequest{Transactions: tt.transactions}, func(e *pqs.Event) bool {
+				return e.Table == "notes"
+			})
+			var got []string
+			for _, e := range tt.events {
+				for _, e := range f.filter(e) {
+					got = append(got, eventSummary(e))
+				}
+			}
+			if got := strings.Join(got, " "); got != tt.want {
+				t.Errorf("boundaryFilter.filter() sent %q, want %q", got, tt.want)
+			}
+		})
+	}
+	// the events are shared with other subscribers, the commit time is set on copies.
+	if events[2].CommitTime != nil {
+		t.Errorf("boundaryFilter.filter() modified %v", eventSummary(events[2]))
+	}
+}
+
+func TestServer_commitTrigger(t *testing.T) {
+	db := dbOrSkip(t)
+	cs, cleanup := testDBConn(t, db, "commit_trigger")
+	defer cleanup()
+	s, err := NewServer(cs, WithLogger(loggerFromT(t)), WithCommitTrigger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.InstallTriggers(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.RemoveTriggers()
+
+	// transactions that only truncate are reported too.
+	for _, q := range []string{testInsert, testTruncate} {
+		if _, err := s.db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+		var ops []string
+		for len(ops) == 0 || ops[len(ops)-1] != pqs.Operation_COMMIT.String() {
+			select {
+			case ev := <-s.l.NotificationChannel():
+				re := &pqs.RawEvent{}
+				if err := jsonpb.UnmarshalString(ev.Extra, re); err != nil {
+					t.Fatal(err)
+				}
+				ops = append(ops, re.Op.String())
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no COMMIT for %v, got %v", q, ops)
+			}
+		}
+		if len(ops) != 2 {
+			t.Errorf("notifications for %v = %v, want a change and COMMIT", q, ops)
+		}
+	}
+	var n int
+	if err := s.db.QueryRow("select count(*) from pqstream_commits").Scan(&n); err != nil || n != 0 {
+		t.Errorf("pqstream_commits has %v rows (%v), want 0", n, err)
+	}
+}
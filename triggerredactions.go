@@ -0,0 +1,98 @@
+package pqstream
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// WithTriggerRedactions makes InstallTriggers create a trigger function for each table that leaves the
+// columns deleted by the field redactions out of notifications, so their values never leave the database.
+// Other redactions, and those of nested fields, are still applied by the server.
+func WithTriggerRedactions() ServerOption {
+	return func(s *Server) {
+		s.triggerRedactions = true
+	}
+}
+
+// tableFunction returns the name of the trigger function of t used with trigger redactions.
+func tableFunction(t table) string {
+	h := fnv.New32a()
+	h.Write([]byte(t.quoted()))
+	return fmt.Sprintf("pqstream_notify_%08x", h.Sum32())
+}
+
+// installTableFunction (re)creates the trigger function of t, excluding the columns deleted by r.
+func (s *Server) installTableFunction(t table, r Redactions) error {
+	opts := s.triggerFunctionOptions()
+	opts.Function = tableFunction(t)
+	var err error
+	if opts.Exclude, err = s.triggerExclusions(r, t); err != nil {
+		return err
+	}
+	fn := &bytes.Buffer{}
+	if err := sqlTriggerFunction.Execute(fn, opts); err != nil {
+		return errors.Wrap(err, "trigger function template")
+	}
+	if _, err := s.db.Exec(fn.String()); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("create trigger function for %s", t))
+	}
+	return nil
+}
+
+// updateTableFunctions recreates the trigger functions of all tables for new redactions.
+func (s *Server) updateTableFunctions(r Redactions) error {
+	if !s.triggerRedactions || s.slot != "" {
+		return nil
+	}
+	tableNames, err := s.tableNames()
+	if err != nil {
+		return err
+	}
+	for _, t := range tableNames {
+		if err := s.installTableFunction(t, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// triggerExclusions returns the columns of t deleted by r.
+func (s *Server) triggerExclusions(r Redactions, t table) ([]string, error) {
+	var patterns []string
+	for _, f := range r.fields(t.schema, t.name) {
+		if f.Strategy != RedactDelete {
+			continue
+		}
+		elems, err := parseRedactionPath(f.Field)
+		if err != nil || len(elems) != 1 || elems[0].each {
+			continue
+		}
+		patterns = append(patterns, elems[0].name)
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	columns, err := s.columnNames(t)
+	if err != nil {
+		return nil, err
+	}
+	return excludedColumns(columns, patterns), nil
+}
+
+// excludedColumns returns the columns matching any of patterns.
+func excludedColumns(columns, patterns []string) []string {
+	var excluded []string
+	for _, c := range columns {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, c); ok {
+				excluded = append(excluded, c)
+				break
+			}
+		}
+	}
+	return excluded
+}
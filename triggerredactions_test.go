@@ -0,0 +1,102 @@
+package pqstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/tmc/pqstream/pqs"
+)
+
+func Test_tableFunction(t *testing.T) {
+	a, b := tableFunction(table{"public", "notes"}), tableFunction(table{"other", "notes"})
+	if a == b {
+		t.Errorf("tableFunction() = %v for tables of different schemas", a)
+	}
+	if a != tableFunction(table{"public", "notes"}) {
+		t.Error("tableFunction() is not stable")
+	}
+	if len(a) > 63 {
+		t.Errorf("tableFunction() = %v, longer than an identifier may be", a)
+	}
+}
+
+func Test_excludedColumns(t *testing.T) {
+	got := excludedColumns([]string{"id", "email", "api_token", "refresh_token", "note"}, []string{"email", "*_token", "missing"})
+	want := []string{"email", "api_token", "refresh_token"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("excludedColumns() = %v, want %v", got, want)
+	}
+}
+
+func Test_triggerFunctionOptions_Row(t *testing.T) {
+	opts := triggerFunctionOptions{Function: "pqstream_notify_1", Exclude: []string{"email", "o'neil"}}
+	if got, want := opts.Row("NEW"), `ARRAY['email', 'o''neil']`; !strings.Contains(got, want) {
+		t.Errorf("Row() = %v, want it to contain %v", got, want)
+	}
+	fn := &bytes.Buffer{}
+	if err := sqlTriggerFunction.Execute(fn, opts); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(fn.String(), "payload = row_to_json(NEW);") || !strings.Contains(fn.String(), "FUNCTION pqstream_notify_1()") {
+		t.Errorf("trigger function does not exclude columns:\n%s", fn)
+	}
+	// the key may include excluded columns.
+	if !strings.Contains(fn.String(), "keyrow = row_to_json(NEW);") {
+		t.Errorf("trigger function does not read the key from the whole row:\n%s", fn)
+	}
+}
+
+func TestServer_triggerRedactions(t *testing.T) {
+	db := dbOrSkip(t)
+	cs, cleanup := testDBConn(t, db, "trigger_redactions")
+	defer cleanup()
+	redactions := Redactions{"public": {"notes": []FieldRedaction{{Field: "note", Strategy: RedactDelete}}}}
+	s, err := NewServer(cs, WithLogger(loggerFromT(t)), WithTriggerRedactions(), WithRedactions(redactions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.InstallTriggers(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.RemoveTriggers()
+
+	// the redacted column is not part of the notification itself.
+	notifiedPayload := func(q string) map[string]interface{} {
+		if _, err := s.db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+		for {
+			select {
+			case ev := <-s.l.NotificationChannel():
+				var n struct {
+					Op      string                 `json:"op"`
+					Payload map[string]interface{} `json:"payload"`
+				}
+				if err := json.Unmarshal([]byte(ev.Extra), &n); err != nil {
+					t.Fatal(err)
+				}
+				if n.Op != pqs.Operation_COMMIT.String() {
+					return n.Payload
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no notification for %v", q)
+			}
+		}
+	}
+	if p := notifiedPayload(testInsert); p == nil || p["note"] != nil {
+		t.Errorf("notified payload = %v, want one without a note", p)
+	}
+
+	// changed redactions are applied to the trigger functions.
+	if err := s.SetRedactions(Redactions{}); err != nil {
+		t.Fatal(err)
+	}
+	if p := notifiedPayload(testInsert); p["note"] == nil {
+		t.Errorf("notified payload = %v, want one with a note", p)
+	}
+}
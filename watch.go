@@ -0,0 +1,105 @@
+package pqstream
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+const (
+	controlChannel                = channel + "-ctl"
+	defaultTableReconcileInterval = time.Minute
+)
+
+// WithTableReconcileInterval controls how often the set of tables is checked for tables created since the
+// triggers were installed, zero disables the periodic check. The check also runs whenever the ddl event
+// trigger reports a new table.
+func WithTableReconcileInterval(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.reconcileInterval = d
+	}
+}
+
+// controlMessage is sent on the control channel by the ddl event trigger.
+type controlMessage struct {
+	Op     string `json:"op"`
+	Schema string `json:"schema"`
+	Object string `json:"object"`
+}
+
+// installTableWatch installs the event trigger that reports new tables on the control channel.
+//
+// Event triggers can only be created by superusers, so failing to install it is not an error;
+// new tables are then picked up by periodic reconciliation alone.
+func (s *Server) installTableWatch() {
+	for _, q := range []string{sqlDDLFunction, sqlRemoveDDLTrigger, sqlInstallDDLTrigger} {
+		if _, err := s.db.Exec(q); err != nil {
+			s.logger.WithError(err).Warnln("could not install ddl event trigger, new tables are found by reconciliation only")
+			return
+		}
+	}
+}
+
+// handleControl handles a notification on the control channel.
+func (s *Server) handleControl(ev *pq.Notification) error {
+	var m controlMessage
+	if err := json.Unmarshal([]byte(ev.Extra), &m); err != nil {
+		s.logger.WithField("control", ev.Extra).WithError(err).Warnln("invalid control message")
+	}
+	s.logger.WithField("control", m).Debugln("got control message")
+	return s.reconcileTables()
+}
+
+// reconcileTables sets up change capture for tables created since InstallTriggers ran. Tables it cannot be set
+// up for are logged, reported by Health and retried the next time.
+func (s *Server) reconcileTables() error {
+	if s.tables == nil {
+		// triggers were not installed by this server.
+		return nil
+	}
+	tableNames, err := s.tableNames()
+	if err != nil {
+		return errors.Wrap(err, "reconcile tables")
+	}
+	// rebuilt each time so that a dropped table is set up again if it is recreated.
+	tables := make(map[table]bool, len(tableNames))
+	var added []table
+	for _, t := range tableNames {
+		tables[t] = true
+		if !s.tables[t] {
+			added = append(added, t)
+		}
+	}
+	failed := make(map[table]error)
+	switch {
+	case len(added) == 0:
+	case s.slot != "":
+		if err := s.installReplication(tableNames); err != nil {
+			for _, t := range added {
+				failed[t] = errors.Wrap(err, "installReplication")
+			}
+		}
+	default:
+		for _, t := range added {
+			if err := s.installTrigger(t); err != nil {
+				failed[t] = errors.Wrap(err, "installTrigger")
+			}
+		}
+	}
+	var unwatched []string
+	for _, t := range added {
+		if err := failed[t]; err != nil {
+			s.logger.WithField("table", t).WithError(err).Errorln("could not watch new table, retrying at the next reconciliation")
+			// left out so it is added again.
+			delete(tables, t)
+			unwatched = append(unwatched, t.String())
+			continue
+		}
+		s.logger.WithField("table", t).Infoln("watching new table")
+	}
+	s.tables = tables
+	s.health.update(func(h *healthStatus) { h.unwatched = unwatched })
+	return nil
+}
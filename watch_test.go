@@ -0,0 +1,38 @@
+package pqstream
+
+import (
+	"testing"
+)
+
+func TestServer_reconcileTables(t *testing.T) {
+	db := dbOrSkip(t)
+	cs, cleanup := testDBConn(t, db, "reconcile")
+	defer cleanup()
+	s, err := NewServer(cs, WithLogger(loggerFromT(t)), WithCommitTrigger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.reconcileTables(); err != nil || s.tables != nil {
+		t.Fatalf("reconcileTables() before InstallTriggers = %v, %v, want no tables", s.tables, err)
+	}
+	if err := s.InstallTriggers(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec(`create table later_notes (id serial, note text)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.reconcileTables(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.tables[table{"public", "later_notes"}] {
+		t.Errorf("reconcileTables() tables = %v, want later_notes", s.tables)
+	}
+	var triggers string
+	if err := s.db.QueryRow(`select string_agg(tgname, ' ' order by tgname) from pg_trigger where tgrelid = 'later_notes'::regclass and not tgisinternal`).Scan(&triggers); err != nil {
+		t.Fatal(err)
+	}
+	if want := "pqstream_notify pqstream_notify_commit pqstream_notify_truncate"; triggers != want {
+		t.Errorf("reconcileTables() installed triggers %q on later_notes, want %q", triggers, want)
+	}
+}